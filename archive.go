@@ -0,0 +1,170 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func Archive(c *cli.Context) {
+	filename := c.String("config")
+	if filename == "" {
+		log.Fatal("config is required")
+	}
+	config, err := gonest.LoadArchiveConfig(filename)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"config": filename,
+			"error":  err,
+		}).Fatal("failed loading archive config")
+	}
+	config.Directory = archivePath(config.Directory)
+	if config.Directory == "" {
+		config.Directory = archiveRoot
+	}
+	if config.Directory == "" {
+		log.WithFields(log.Fields{
+			"config": filename,
+		}).Fatal("archive config has no directory and no --archive-root is set")
+	}
+
+	nest.Load()
+	nest.Login()
+	nest.Save()
+
+	state, err := gonest.LoadArchiveState(config.Directory)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": config.Directory,
+			"error":     err,
+		}).Fatal("failed loading archive state")
+	}
+	manifest := loadManifest(config.Directory)
+	namer := makeClipNamer(config.NameTemplate, config.Location())
+
+	_, err = gonest.CleanupPartialDownloads(config.Directory, c.Duration("partial-max-age"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": config.Directory,
+			"error":     err,
+		}).Error("failed cleaning up partial downloads")
+	}
+
+	for {
+		for _, camera := range config.Cameras {
+			if camera.Disabled {
+				continue
+			}
+			archiveCamera(c, config, camera, state, namer, manifest)
+		}
+		if !config.Retention.Empty() {
+			pruneArchive(manifest, config.Retention, false, false)
+		}
+		if c.Bool("once") {
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"interval": time.Duration(config.Interval),
+		}).Info("waiting for the next windows")
+		time.Sleep(time.Duration(config.Interval))
+	}
+}
+
+// archiveCamera exports every finished window of camera. A job keeps its range
+// until it is done, so after a restart the same checkpoint is picked up and
+// windows are neither skipped nor downloaded twice.
+func archiveCamera(c *cli.Context, config *gonest.ArchiveConfig, camera gonest.ArchiveCamera, state *gonest.ArchiveState, namer *clipNamer, manifest *gonest.Manifest) {
+	cameraState := state.Camera(camera.UUID)
+	if cameraState.Job == nil {
+		job, gap, ok := camera.NextJob(cameraState.Archived, time.Now(), config.Location())
+		if gap != nil {
+			log.WithFields(log.Fields{
+				"camera": camera.UUID,
+				"gap":    gap,
+			}).Warn("skipping history older than the backfill limit")
+			cameraState.Gaps = append(cameraState.Gaps, *gap)
+			cameraState.Archived = gap.End
+		}
+		if !ok {
+			saveArchiveState(state)
+			return
+		}
+		cameraState.Job = &job
+		cameraState.Attempts = 0
+		saveArchiveState(state)
+	}
+	job := *cameraState.Job
+
+	checkpoint, err := gonest.LoadCheckpoint(gonest.CheckpointFilename(config.Directory, camera.UUID, job), camera.UUID, job)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"camera": camera.UUID,
+			"error":  err,
+		}).Error("failed loading checkpoint")
+		return
+	}
+
+	windows := camera.Windows(job, config.Location())
+	log.WithFields(log.Fields{
+		"camera":  camera.UUID,
+		"job":     job,
+		"windows": len(windows),
+		"attempt": cameraState.Attempts + 1,
+	}).Info("archiving camera")
+
+	failed, left := exportVideo(c, camera.UUID, config.Directory, windows, checkpoint, namer, manifest)
+	reportLeftClips(left)
+	cameraState.Attempts += 1
+
+	if failed > 0 || len(checkpoint.Leftovers()) > 0 {
+		if cameraState.Attempts < config.MaxAttempts {
+			log.WithFields(log.Fields{
+				"camera":   camera.UUID,
+				"job":      job,
+				"failed":   failed,
+				"attempts": cameraState.Attempts,
+			}).Error("archive job incomplete, retrying next run")
+			saveArchiveState(state)
+			return
+		}
+		for _, window := range checkpoint.Pending(windows) {
+			cameraState.Gaps = append(cameraState.Gaps, window)
+		}
+		log.WithFields(log.Fields{
+			"camera":   camera.UUID,
+			"job":      job,
+			"failed":   failed,
+			"attempts": cameraState.Attempts,
+		}).Error("giving up on archive job, missing windows recorded as gaps")
+	}
+
+	cameraState.Archived = job.End
+	cameraState.Job = nil
+	cameraState.Attempts = 0
+	saveArchiveState(state)
+
+	// leftover clips stay in the checkpoint until someone deals with them
+	if len(checkpoint.Leftovers()) == 0 {
+		err = checkpoint.Remove()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"checkpoint": checkpoint.Filename,
+				"error":      err,
+			}).Error("failed removing checkpoint")
+		}
+	}
+}
+
+func saveArchiveState(state *gonest.ArchiveState) {
+	err := state.Save()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"state": filepath.Base(state.Filename),
+			"error": err,
+		}).Error("failed saving archive state")
+	}
+}
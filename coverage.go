@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func Coverage(c *cli.Context) {
+	requested := videoRange(c)
+	directory := archiveDirectory(c, ".")
+	manifest := loadManifest(directory)
+
+	cameras := manifest.Cameras()
+	if c.String("id") != "" {
+		cameras = []string{c.String("id")}
+	}
+	if len(cameras) == 0 {
+		log.Fatal("no cameras in the manifest, use --id")
+	}
+
+	coverages := make(map[string]gonest.Coverage)
+	gaps := 0
+	for _, id := range cameras {
+		coverage := checkCoverage(c, manifest, id, requested)
+		coverages[id] = coverage
+		gaps += len(coverage.Gaps)
+	}
+	if gaps == 0 {
+		return
+	}
+
+	if !c.Bool("fill-gaps") {
+		log.WithFields(log.Fields{
+			"gaps": gaps,
+		}).Error("video is incomplete, use --fill-gaps to request the missing ranges again")
+		os.Exit(1)
+	}
+	if !c.Bool("yes") && !confirm(fmt.Sprintf("Request %d missing ranges again?", gaps)) {
+		log.Info("not requesting missing ranges")
+		os.Exit(1)
+	}
+
+	nest.Load()
+	nest.Login()
+	nest.Save()
+
+	namer := newClipNamer(c)
+	incomplete := false
+	for _, id := range cameras {
+		if coverages[id].Complete() {
+			continue
+		}
+		_, left := fillGaps(c, id, directory, coverages[id], namer, manifest)
+		reportLeftClips(left)
+		if !checkCoverage(c, manifest, id, requested).Complete() {
+			incomplete = true
+		}
+	}
+	if incomplete {
+		os.Exit(1)
+	}
+}
+
+// checkCoverage compares the downloads of camera id in the manifest with the
+// requested range and logs the result
+func checkCoverage(c *cli.Context, manifest *gonest.Manifest, id string, requested gonest.TimeRange) gonest.Coverage {
+	coverage := gonest.CheckCoverage(requested, manifest.Ranges(id, requested), c.Duration("gap-tolerance"))
+
+	for _, gap := range coverage.Gaps {
+		log.WithFields(log.Fields{
+			"camera": id,
+			"gap":    gap,
+			"length": gap.Length(),
+		}).Warn("missing video")
+	}
+	for _, overlap := range coverage.Overlaps {
+		log.WithFields(log.Fields{
+			"camera":  id,
+			"overlap": overlap,
+			"length":  overlap.Length(),
+		}).Warn("video downloaded more than once")
+	}
+	log.WithFields(log.Fields{
+		"camera":    id,
+		"range":     requested,
+		"covered":   coverage.Covered,
+		"requested": requested.Length(),
+		"gaps":      len(coverage.Gaps),
+		"overlaps":  len(coverage.Overlaps),
+	}).Info("checked coverage")
+	return coverage
+}
+
+// fillGaps requests every gap of coverage again, each gap gets its own
+// checkpoint so an interrupted fill can be resumed too. The segments which
+// were filled are returned along with any clips left on the server.
+func fillGaps(c *cli.Context, id string, directory string, coverage gonest.Coverage, namer *clipNamer, manifest *gonest.Manifest) ([]gonest.CheckpointSegment, []gonest.ExportResult) {
+	var filled []gonest.CheckpointSegment
+	var left []gonest.ExportResult
+	for _, gap := range coverage.Gaps {
+		gap.Start = gap.Start.Truncate(time.Second)
+		segments, err := gonest.SplitRange(gap, gonest.MaxClipLength)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"gap":   gap,
+				"error": err,
+			}).Error("unable to request gap")
+			continue
+		}
+
+		checkpoint, err := gonest.LoadCheckpoint(gonest.CheckpointFilename(directory, id, gap), id, gap)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"gap":   gap,
+				"error": err,
+			}).Error("failed loading checkpoint")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"camera": id,
+			"gap":    gap,
+		}).Info("requesting missing video")
+		failed, gapLeft := exportVideo(c, id, directory, segments, checkpoint, namer, manifest)
+		filled = append(filled, checkpoint.Segments()...)
+		left = append(left, gapLeft...)
+		if failed == 0 && len(checkpoint.Leftovers()) == 0 {
+			err = checkpoint.Remove()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"checkpoint": checkpoint.Filename,
+					"error":      err,
+				}).Error("failed removing checkpoint")
+			}
+		}
+	}
+	return filled, left
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// archiveRoot is the --archive-root flag, or archive_root from the config,
+// made absolute so commands behave the same from any working directory
+var archiveRoot string
+
+// cameraDirectories is the --camera-dirs flag or camera_directories from the
+// config
+var cameraDirectories bool
+
+// configureDirectories applies the global directory flags, falling back to
+// the saved config for any that weren't given
+func configureDirectories(c *cli.Context) error {
+	nest.Load()
+
+	root := c.GlobalString("archive-root")
+	if root == "" {
+		root = nest.ArchiveRoot
+	}
+	if root != "" {
+		absolute, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		archiveRoot = absolute
+	}
+
+	mode := c.GlobalString("dir-mode")
+	if mode == "" {
+		mode = nest.DirectoryMode
+	}
+	if mode != "" {
+		parsed, err := gonest.ParseDirectoryMode(mode)
+		if err != nil {
+			return err
+		}
+		gonest.DirectoryMode = parsed
+	}
+
+	cameraDirectories = c.GlobalBool("camera-dirs") || nest.CameraDirectories
+	return nil
+}
+
+// archivePath resolves a relative path against the archive root
+func archivePath(path string) string {
+	if path == "" || archiveRoot == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(archiveRoot, path)
+}
+
+// archiveDirectory is the --directory flag resolved against the archive
+// root, the root itself when the flag is empty and fallback when neither is
+// set
+func archiveDirectory(c *cli.Context, fallback string) string {
+	directory := c.String("directory")
+	if directory == "" {
+		directory = archiveRoot
+	} else {
+		directory = archivePath(directory)
+	}
+	if directory == "" {
+		directory = fallback
+	}
+	return directory
+}
+
+// requireArchiveDirectory is archiveDirectory for commands which must not
+// guess where the archive is
+func requireArchiveDirectory(c *cli.Context) string {
+	directory := archiveDirectory(c, "")
+	if directory == "" {
+		log.Fatal("directory is required, use --directory or --archive-root")
+	}
+	return directory
+}
@@ -0,0 +1,247 @@
+package gonest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+)
+
+const ArchiveStateFilename = ".gonest-archive-state.json"
+
+// Duration reads and writes durations in config files as strings like "15m"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+type ArchiveConfig struct {
+	// Directory is the archive directory, it holds the manifest and state.
+	// It may be left to the caller, such as to a global archive root.
+	Directory    string `json:"directory"`
+	NameTemplate string `json:"name_template"`
+	Timezone     string `json:"timezone"`
+	// Interval is how often cameras are checked for finished windows,
+	// defaults to 5 minutes
+	Interval Duration `json:"interval"`
+	// MaxAttempts is how many runs a window gets before it is given up on
+	// and recorded as a gap, defaults to 5
+	MaxAttempts int             `json:"max_attempts"`
+	Cameras     []ArchiveCamera `json:"cameras"`
+	// Retention is applied to the archive after every run
+	Retention RetentionPolicy `json:"retention"`
+
+	location *time.Location
+}
+
+// ArchiveCamera is the schedule for one camera. History is archived in
+// windows aligned to local midnight, each window is requested once it has
+// been over for Delay.
+type ArchiveCamera struct {
+	UUID string `json:"uuid"`
+	// Window defaults to, and is capped at, MaxClipLength
+	Window Duration `json:"window"`
+	// Delay gives the camera time to upload, defaults to 5 minutes
+	Delay Duration `json:"delay"`
+	// Hours limits archiving to local times of day such as 07:00-22:00,
+	// empty means all day
+	Hours string `json:"hours"`
+	// Backfill is the most history caught up on after downtime, defaults to
+	// 24 hours
+	Backfill Duration `json:"backfill"`
+	Disabled bool     `json:"disabled"`
+
+	hoursStart time.Duration
+	hoursEnd   time.Duration
+}
+
+func LoadArchiveConfig(filename string) (*ArchiveConfig, error) {
+	config := &ArchiveConfig{}
+	err := golib.LoadFile(filename, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.NameTemplate == "" {
+		config.NameTemplate = "{{.Camera}}/{{.Start.Format \"2006/01/02\"}}/{{.Start.Format \"15-04-05\"}}.mp4"
+	}
+	if config.Timezone == "" {
+		config.Timezone = "Local"
+	}
+	config.location, err = time.LoadLocation(config.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if config.Interval <= 0 {
+		config.Interval = Duration(5 * time.Minute)
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if len(config.Cameras) == 0 {
+		return nil, fmt.Errorf("%s: no cameras configured", filename)
+	}
+
+	for i := range config.Cameras {
+		camera := &config.Cameras[i]
+		if camera.UUID == "" {
+			return nil, fmt.Errorf("%s: camera %d has no uuid", filename, i+1)
+		}
+		if camera.Window <= 0 || time.Duration(camera.Window) > MaxClipLength {
+			camera.Window = Duration(MaxClipLength)
+		}
+		if camera.Delay <= 0 {
+			camera.Delay = Duration(5 * time.Minute)
+		}
+		if camera.Backfill <= 0 {
+			camera.Backfill = Duration(24 * time.Hour)
+		}
+		if camera.Hours != "" {
+			dash := strings.Index(camera.Hours, "-")
+			if dash == -1 {
+				return nil, fmt.Errorf("%s: invalid hours %q for %s, expected HH:MM-HH:MM", filename, camera.Hours, camera.UUID)
+			}
+			camera.hoursStart, err = parseClock(camera.Hours[:dash])
+			if err != nil {
+				return nil, err
+			}
+			camera.hoursEnd, err = parseClock(camera.Hours[dash+1:])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return config, nil
+}
+
+func (c *ArchiveConfig) Location() *time.Location {
+	return c.location
+}
+
+// align rounds t down to the start of the window it falls in
+func (c ArchiveCamera) align(t time.Time, location *time.Location) time.Time {
+	midnight := startOfDay(t.In(location))
+	return midnight.Add(t.Sub(midnight).Truncate(time.Duration(c.Window)))
+}
+
+// NextJob returns the range of finished windows which haven't been archived
+// yet. The first run only takes the latest window, after downtime at most
+// Backfill is caught up on and the skipped range is returned as a gap.
+func (c ArchiveCamera) NextJob(archived time.Time, now time.Time, location *time.Location) (job TimeRange, gap *TimeRange, ok bool) {
+	end := c.align(now.Add(-time.Duration(c.Delay)), location)
+	start := archived
+	if start.IsZero() {
+		start = end.Add(-time.Duration(c.Window))
+	}
+	if oldest := c.align(end.Add(-time.Duration(c.Backfill)), location); start.Before(oldest) {
+		gap = &TimeRange{Start: start, End: oldest}
+		start = oldest
+	}
+	if !end.After(start) {
+		return TimeRange{}, gap, false
+	}
+	return TimeRange{Start: start, End: end}, gap, true
+}
+
+// Windows splits a job into clips, dropping windows outside Hours
+func (c ArchiveCamera) Windows(job TimeRange, location *time.Location) []TimeRange {
+	var windows []TimeRange
+	for start := job.Start; start.Before(job.End); {
+		end := c.align(start, location).Add(time.Duration(c.Window))
+		if end.After(job.End) {
+			end = job.End
+		}
+		if c.inHours(start, location) {
+			windows = append(windows, TimeRange{Start: start, End: end})
+		}
+		start = end
+	}
+	return windows
+}
+
+func (c ArchiveCamera) inHours(t time.Time, location *time.Location) bool {
+	if c.Hours == "" {
+		return true
+	}
+	local := t.In(location)
+	offset := local.Sub(startOfDay(local))
+	if c.hoursStart <= c.hoursEnd {
+		return offset >= c.hoursStart && offset < c.hoursEnd
+	}
+	return offset >= c.hoursStart || offset < c.hoursEnd
+}
+
+type ArchiveCameraState struct {
+	// Archived is the end of the last window which is done with
+	Archived time.Time `json:"archived"`
+	// Job is the range being worked on, it is kept across restarts so the
+	// checkpoint for it can be found again
+	Job      *TimeRange  `json:"job,omitempty"`
+	Attempts int         `json:"attempts"`
+	Gaps     []TimeRange `json:"gaps,omitempty"`
+}
+
+// ArchiveState is what the archive command remembers between runs
+type ArchiveState struct {
+	Filename string                         `json:"-"`
+	Cameras  map[string]*ArchiveCameraState `json:"cameras"`
+
+	mu sync.Mutex
+}
+
+func LoadArchiveState(directory string) (*ArchiveState, error) {
+	s := &ArchiveState{
+		Filename: filepath.Join(directory, ArchiveStateFilename),
+		Cameras:  make(map[string]*ArchiveCameraState),
+	}
+	err := golib.LoadFile(s.Filename, s)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if s.Cameras == nil {
+		s.Cameras = make(map[string]*ArchiveCameraState)
+	}
+	return s, nil
+}
+
+func (s *ArchiveState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := mkdirAll(filepath.Dir(s.Filename))
+	if err != nil {
+		return err
+	}
+	return golib.SaveFile(s.Filename, s)
+}
+
+// Camera returns the state for uuid, creating it if needed
+func (s *ArchiveState) Camera(uuid string) *ArchiveCameraState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.Cameras[uuid]
+	if !ok {
+		state = &ArchiveCameraState{}
+		s.Cameras[uuid] = state
+	}
+	return state
+}
@@ -0,0 +1,245 @@
+package gonest
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testArchiveCamera loads a config holding camera, so the defaults and hours
+// parsing are applied the way the archive command sees them
+func testArchiveCamera(t *testing.T, camera string) ArchiveCamera {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "archive.json")
+	writeTestFile(t, filename, []byte(fmt.Sprintf(`{"cameras": [%s]}`, camera)))
+	config, err := LoadArchiveConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Cameras[0]
+}
+
+var archiveLocation = time.FixedZone("EST", -5*60*60)
+
+// clock returns hour:minute on the given day of November 2023 in
+// archiveLocation
+func clock(day, hour, minute int) time.Time {
+	return time.Date(2023, 11, day, hour, minute, 0, 0, archiveLocation)
+}
+
+func TestArchiveNextJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		camera   string
+		archived time.Time
+		now      time.Time
+		job      TimeRange
+		gap      *TimeRange
+		ok       bool
+	}{
+		{
+			name:   "first run takes the latest window",
+			camera: `{"uuid": "a"}`,
+			now:    clock(14, 10, 7),
+			job:    TimeRange{Start: clock(14, 9, 0), End: clock(14, 10, 0)},
+			ok:     true,
+		},
+		{
+			name:     "window not over for the delay yet",
+			camera:   `{"uuid": "a"}`,
+			archived: clock(14, 10, 0),
+			now:      clock(14, 11, 3),
+		},
+		{
+			name:     "resumes from the archived time",
+			camera:   `{"uuid": "a"}`,
+			archived: clock(14, 7, 0),
+			now:      clock(14, 10, 30),
+			job:      TimeRange{Start: clock(14, 7, 0), End: clock(14, 10, 0)},
+			ok:       true,
+		},
+		{
+			name:     "downtime beyond the backfill is a gap",
+			camera:   `{"uuid": "a"}`,
+			archived: clock(12, 8, 0),
+			now:      clock(14, 10, 30),
+			job:      TimeRange{Start: clock(13, 10, 0), End: clock(14, 10, 0)},
+			gap:      &TimeRange{Start: clock(12, 8, 0), End: clock(13, 10, 0)},
+			ok:       true,
+		},
+		{
+			name:   "short windows and a long delay",
+			camera: `{"uuid": "a", "window": "15m", "delay": "20m"}`,
+			now:    clock(14, 10, 22),
+			job:    TimeRange{Start: clock(14, 9, 45), End: clock(14, 10, 0)},
+			ok:     true,
+		},
+		{
+			name:     "over midnight",
+			camera:   `{"uuid": "a"}`,
+			archived: clock(13, 22, 0),
+			now:      clock(14, 1, 10),
+			job:      TimeRange{Start: clock(13, 22, 0), End: clock(14, 1, 0)},
+			ok:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			camera := testArchiveCamera(t, test.camera)
+			job, gap, ok := camera.NextJob(test.archived, test.now, archiveLocation)
+			if ok != test.ok {
+				t.Fatalf("got ok %t, expected %t", ok, test.ok)
+			}
+			if ok && (!job.Start.Equal(test.job.Start) || !job.End.Equal(test.job.End)) {
+				t.Errorf("got job %s, expected %s", job, test.job)
+			}
+			if (gap == nil) != (test.gap == nil) || (gap != nil && (!gap.Start.Equal(test.gap.Start) || !gap.End.Equal(test.gap.End))) {
+				t.Errorf("got gap %v, expected %v", gap, test.gap)
+			}
+		})
+	}
+}
+
+func TestArchiveNextJobFromState(t *testing.T) {
+	camera := testArchiveCamera(t, `{"uuid": "a"}`)
+	directory := t.TempDir()
+
+	state, err := LoadArchiveState(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, _, ok := camera.NextJob(state.Camera("a").Archived, clock(14, 10, 7), archiveLocation)
+	if !ok {
+		t.Fatal("no job on the first run")
+	}
+	state.Camera("a").Archived = job.End
+	err = state.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart hours later picks up where the saved state ends
+	state, err = LoadArchiveState(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, gap, ok := camera.NextJob(state.Camera("a").Archived, clock(14, 13, 30), archiveLocation)
+	expected := TimeRange{Start: clock(14, 10, 0), End: clock(14, 13, 0)}
+	if !ok || gap != nil || !job.Start.Equal(expected.Start) || !job.End.Equal(expected.End) {
+		t.Errorf("got job %s gap %v, expected %s", job, gap, expected)
+	}
+}
+
+func TestArchiveWindows(t *testing.T) {
+	hourly := func(day, from, count int) []TimeRange {
+		var windows []TimeRange
+		start := clock(day, from, 0)
+		for i := 0; i < count; i++ {
+			windows = append(windows, TimeRange{Start: start, End: start.Add(time.Hour)})
+			start = start.Add(time.Hour)
+		}
+		return windows
+	}
+
+	tests := []struct {
+		name     string
+		camera   string
+		job      TimeRange
+		expected []TimeRange
+	}{
+		{
+			name:   "unaligned job",
+			camera: `{"uuid": "a"}`,
+			job:    TimeRange{Start: clock(14, 9, 30), End: clock(14, 11, 45)},
+			expected: []TimeRange{
+				{Start: clock(14, 9, 30), End: clock(14, 10, 0)},
+				{Start: clock(14, 10, 0), End: clock(14, 11, 0)},
+				{Start: clock(14, 11, 0), End: clock(14, 11, 45)},
+			},
+		},
+		{
+			name:     "daytime hours",
+			camera:   `{"uuid": "a", "hours": "07:00-22:00"}`,
+			job:      TimeRange{Start: clock(14, 5, 0), End: clock(14, 9, 0)},
+			expected: hourly(14, 7, 2),
+		},
+		{
+			name:     "overnight hours",
+			camera:   `{"uuid": "a", "hours": "22:00-06:00"}`,
+			job:      TimeRange{Start: clock(13, 20, 0), End: clock(14, 8, 0)},
+			expected: append(hourly(13, 22, 2), hourly(14, 0, 6)...),
+		},
+		{
+			name:   "windows capped at the maximum clip length",
+			camera: `{"uuid": "a", "window": "3h"}`,
+			job:    TimeRange{Start: clock(14, 0, 0), End: clock(14, 2, 0)},
+			expected: []TimeRange{
+				{Start: clock(14, 0, 0), End: clock(14, 1, 0)},
+				{Start: clock(14, 1, 0), End: clock(14, 2, 0)},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			camera := testArchiveCamera(t, test.camera)
+			windows := camera.Windows(test.job, archiveLocation)
+			if !equalRanges(windows, test.expected) {
+				t.Errorf("got %v, expected %v", windows, test.expected)
+			}
+		})
+	}
+}
+
+func TestArchiveInHours(t *testing.T) {
+	overnight := testArchiveCamera(t, `{"uuid": "a", "hours": "22:00-06:00"}`)
+	daytime := testArchiveCamera(t, `{"uuid": "a", "hours": "07:00-22:00"}`)
+	allDay := testArchiveCamera(t, `{"uuid": "a"}`)
+
+	tests := []struct {
+		at        time.Time
+		overnight bool
+		daytime   bool
+	}{
+		{at: clock(14, 0, 0), overnight: true},
+		{at: clock(14, 5, 59), overnight: true},
+		{at: clock(14, 6, 0)},
+		{at: clock(14, 7, 0), daytime: true},
+		{at: clock(14, 12, 0), daytime: true},
+		{at: clock(14, 21, 59), daytime: true},
+		{at: clock(14, 22, 0), overnight: true},
+		{at: clock(14, 23, 59), overnight: true},
+		// the time of day is taken in the archive location
+		{at: clock(14, 23, 0).UTC(), overnight: true},
+	}
+	for _, test := range tests {
+		if in := overnight.inHours(test.at, archiveLocation); in != test.overnight {
+			t.Errorf("%s overnight: got %t, expected %t", test.at, in, test.overnight)
+		}
+		if in := daytime.inHours(test.at, archiveLocation); in != test.daytime {
+			t.Errorf("%s daytime: got %t, expected %t", test.at, in, test.daytime)
+		}
+		if !allDay.inHours(test.at, archiveLocation) {
+			t.Errorf("%s is outside all day hours", test.at)
+		}
+	}
+}
+
+func TestLoadArchiveConfigErrors(t *testing.T) {
+	for _, config := range []string{
+		`{"cameras": []}`,
+		`{"cameras": [{"window": "1h"}]}`,
+		`{"cameras": [{"uuid": "a", "hours": "07:00"}]}`,
+		`{"cameras": [{"uuid": "a", "hours": "07:00-25:00"}]}`,
+		`{"timezone": "Nowhere/Special", "cameras": [{"uuid": "a"}]}`,
+	} {
+		filename := filepath.Join(t.TempDir(), "archive.json")
+		writeTestFile(t, filename, []byte(config))
+		_, err := LoadArchiveConfig(filename)
+		if err == nil {
+			t.Errorf("%s: loaded", config)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package gonest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// atom is an mp4 box held in memory, used to rewrite moov boxes. Container
+// atoms have Children, everything else keeps its payload in Data.
+type atom struct {
+	Type     string
+	Data     []byte
+	Children []*atom
+}
+
+var containerAtoms = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"edts": true,
+	"dinf": true,
+	"udta": true,
+	"mvex": true,
+}
+
+func parseAtoms(data []byte) ([]*atom, error) {
+	boxes, err := readBoxes(bytes.NewReader(data), 0, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	var atoms []*atom
+	for _, box := range boxes {
+		payload := data[box.DataOffset() : box.Offset+box.Size]
+		a := &atom{Type: box.Type}
+		if containerAtoms[box.Type] {
+			a.Children, err = parseAtoms(payload)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			a.Data = payload
+		}
+		atoms = append(atoms, a)
+	}
+	return atoms, nil
+}
+
+func (a *atom) Size() int64 {
+	size := int64(8)
+	if a.Children == nil {
+		size += int64(len(a.Data))
+	}
+	for _, child := range a.Children {
+		size += child.Size()
+	}
+	if size > math.MaxUint32 {
+		size += 8
+	}
+	return size
+}
+
+func (a *atom) WriteTo(w io.Writer) (int64, error) {
+	size := a.Size()
+	var header []byte
+	if size > math.MaxUint32 {
+		header = make([]byte, 16)
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		copy(header[4:8], a.Type)
+		binary.BigEndian.PutUint64(header[8:16], uint64(size))
+	} else {
+		header = make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:4], uint32(size))
+		copy(header[4:8], a.Type)
+	}
+
+	written, err := w.Write(header)
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	if a.Children == nil {
+		written, err = w.Write(a.Data)
+		return total + int64(written), err
+	}
+	for _, child := range a.Children {
+		n, err := child.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (a *atom) Child(atomType string) *atom {
+	for _, child := range a.Children {
+		if child.Type == atomType {
+			return child
+		}
+	}
+	return nil
+}
+
+// Path follows a chain of child types, returning nil if any is missing
+func (a *atom) Path(types ...string) *atom {
+	current := a
+	for _, atomType := range types {
+		current = current.Child(atomType)
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+func (a *atom) All(atomType string) []*atom {
+	var found []*atom
+	for _, child := range a.Children {
+		if child.Type == atomType {
+			found = append(found, child)
+		}
+		found = append(found, child.All(atomType)...)
+	}
+	return found
+}
+
+func (a *atom) Remove(atomType string) {
+	var kept []*atom
+	for _, child := range a.Children {
+		if child.Type != atomType {
+			kept = append(kept, child)
+		}
+	}
+	a.Children = kept
+}
+
+// shiftChunkOffsets moves every chunk offset by delta, stco tables are
+// upgraded to co64 when the new offsets no longer fit
+func (a *atom) shiftChunkOffsets(delta int64) error {
+	// both are collected up front, an upgraded stco must not be shifted twice
+	for _, chunks := range append(a.All("stco"), a.All("co64")...) {
+		offsets, err := chunkOffsets(chunks)
+		if err != nil {
+			return err
+		}
+		for i := range offsets {
+			offsets[i] = uint64(int64(offsets[i]) + delta)
+		}
+		setChunkOffsets(chunks, offsets)
+	}
+	return nil
+}
+
+func chunkOffsets(a *atom) ([]uint64, error) {
+	width := 4
+	if a.Type == "co64" {
+		width = 8
+	}
+	if len(a.Data) < 8 {
+		return nil, fmt.Errorf("%w: short %s box", ErrNotMP4, a.Type)
+	}
+	count := int(binary.BigEndian.Uint32(a.Data[4:8]))
+	if len(a.Data) < 8+count*width {
+		return nil, fmt.Errorf("%w: short %s box", ErrNotMP4, a.Type)
+	}
+
+	offsets := make([]uint64, count)
+	for i := range offsets {
+		entry := a.Data[8+i*width:]
+		if width == 4 {
+			offsets[i] = uint64(binary.BigEndian.Uint32(entry))
+		} else {
+			offsets[i] = binary.BigEndian.Uint64(entry)
+		}
+	}
+	return offsets, nil
+}
+
+// setChunkOffsets writes offsets back to a stco or co64 atom, switching to
+// co64 when needed
+func setChunkOffsets(a *atom, offsets []uint64) {
+	width := 4
+	for _, offset := range offsets {
+		if offset > math.MaxUint32 {
+			width = 8
+		}
+	}
+	if a.Type == "co64" {
+		width = 8
+	}
+
+	data := make([]byte, 8+len(offsets)*width)
+	binary.BigEndian.PutUint32(data[4:8], uint32(len(offsets)))
+	for i, offset := range offsets {
+		if width == 4 {
+			binary.BigEndian.PutUint32(data[8+i*4:], uint32(offset))
+		} else {
+			binary.BigEndian.PutUint64(data[8+i*8:], offset)
+		}
+	}
+	if width == 8 {
+		a.Type = "co64"
+	}
+	a.Data = data
+}
+
+// rewriteMoov applies edit to the moov box of filename, fixing up chunk
+// offsets when the moov sits in front of the media data and changes size
+func rewriteMoov(filename string, edit func(moov *atom) error) error {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := readBoxes(fh, 0, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	moovIndex := -1
+	for i, box := range boxes {
+		if box.Type == "moov" {
+			moovIndex = i
+		}
+	}
+	if moovIndex == -1 {
+		return fmt.Errorf("%w: missing moov box", ErrNotMP4)
+	}
+	moovBox := boxes[moovIndex]
+
+	data := make([]byte, moovBox.DataSize())
+	_, err = fh.ReadAt(data, moovBox.DataOffset())
+	if err != nil {
+		return err
+	}
+	children, err := parseAtoms(data)
+	if err != nil {
+		return err
+	}
+	moov := &atom{Type: "moov", Children: children}
+
+	err = edit(moov)
+	if err != nil {
+		return err
+	}
+
+	mediaFollows := false
+	for _, box := range boxes[moovIndex+1:] {
+		if box.Type == "mdat" {
+			mediaFollows = true
+		}
+	}
+	if mediaFollows {
+		moov, err = moov.settleChunkOffsets(moovBox.Size)
+		if err != nil {
+			return err
+		}
+	}
+
+	rewritten := fmt.Sprintf("%s.rewrite", filename)
+	out, err := os.Create(rewritten)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(rewritten)
+	defer out.Close()
+
+	_, err = io.Copy(out, io.NewSectionReader(fh, 0, moovBox.Offset))
+	if err != nil {
+		return err
+	}
+	_, err = moov.WriteTo(out)
+	if err != nil {
+		return err
+	}
+	after := moovBox.Offset + moovBox.Size
+	_, err = io.Copy(out, io.NewSectionReader(fh, after, stat.Size()-after))
+	if err != nil {
+		return err
+	}
+	err = out.Sync()
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(rewritten, filename)
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+// settleChunkOffsets moves every chunk offset by how much moov grew from size,
+// which is how far the media data after it moves, and returns the result
+func (a *atom) settleChunkOffsets(size int64) (*atom, error) {
+	// shifting can turn stco into co64 which grows the moov again
+	for i := 0; ; i++ {
+		delta := a.Size() - size
+		shifted := &atom{Type: a.Type, Children: cloneAtoms(a.Children)}
+		err := shifted.shiftChunkOffsets(delta)
+		if err != nil {
+			return nil, err
+		}
+		if shifted.Size()-size == delta {
+			return shifted, nil
+		}
+		if i > 2 {
+			return nil, errors.New("unable to settle moov size")
+		}
+		a.upgradeChunkOffsets()
+	}
+}
+
+func (a *atom) upgradeChunkOffsets() {
+	for _, stco := range a.All("stco") {
+		offsets, err := chunkOffsets(stco)
+		if err != nil {
+			continue
+		}
+		stco.Type = "co64"
+		setChunkOffsets(stco, offsets)
+	}
+}
+
+func cloneAtoms(atoms []*atom) []*atom {
+	var cloned []*atom
+	for _, a := range atoms {
+		c := &atom{Type: a.Type}
+		if a.Children != nil {
+			c.Children = cloneAtoms(a.Children)
+		} else {
+			c.Data = append([]byte(nil), a.Data...)
+		}
+		cloned = append(cloned, c)
+	}
+	return cloned
+}
@@ -0,0 +1,61 @@
+package gonest
+
+import (
+	"fmt"
+)
+
+type Camera struct {
+	nest *Nest
+
+	ID                  int    `json:"id"`
+	UUID                string `json:"uuid"`
+	Name                string `json:"name"`
+	Description         string `json:"description"`
+	IsOnline            bool   `json:"is_online"`
+	IsStreaming         bool   `json:"is_streaming"`
+	NexusAPIHTTPServer  string `json:"nexus_api_http_server"`
+	NestStructureID     string `json:"nest_structure_id"`
+	TimezoneUTCOffset   int    `json:"timezone_utc_offset"`
+	Timezone            string `json:"timezone"`
+	HoursOfRecordingMax int    `json:"hours_of_recording_max"`
+}
+
+type CameraListResponse struct {
+	Cameras           []*Camera `json:"items"`
+	Status            int       `json:"status"`
+	StatusDescription string    `json:"status_description"`
+	StatusDetail      string    `json:"status_detail"`
+}
+
+// https://webapi.camera.home.nest.com/api/cameras.get_owned_and_member_of_with_properties
+func (n *Nest) ListCameras() ([]*Camera, error) {
+	var cameraResponse CameraListResponse
+	err := n.GetJSONUnmarsahl("https://webapi.camera.home.nest.com/api/cameras.get_owned_and_member_of_with_properties", &cameraResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if cameraResponse.Status > 0 {
+		return nil, fmt.Errorf("%d: %s: %s", cameraResponse.Status, cameraResponse.StatusDescription, cameraResponse.StatusDetail)
+	}
+
+	for _, camera := range cameraResponse.Cameras {
+		camera.nest = n
+	}
+
+	return cameraResponse.Cameras, nil
+}
+
+// CameraNames maps camera uuids to their names
+func (n *Nest) CameraNames() (map[string]string, error) {
+	cameras, err := n.ListCameras()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	for _, camera := range cameras {
+		names[camera.UUID] = camera.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,358 @@
+package gonest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+type CheckpointSegment struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ClipID   int       `json:"clip_id,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	// Fill marks a segment re-requested to cover a gap inside an earlier one
+	Fill bool `json:"fill,omitempty"`
+}
+
+func (s CheckpointSegment) Range() TimeRange {
+	return TimeRange{Start: s.Start, End: s.End}
+}
+
+// Checkpoint records the progress of an export so an interrupted run can
+// pick up where it stopped instead of requesting every segment again
+type Checkpoint struct {
+	Filename  string              `json:"-"`
+	UUID      string              `json:"uuid"`
+	Start     time.Time           `json:"start"`
+	End       time.Time           `json:"end"`
+	Completed []CheckpointSegment `json:"completed"`
+	// InFlight are clips which were requested but not yet deleted
+	InFlight []CheckpointSegment `json:"in_flight"`
+	Updated  time.Time           `json:"updated"`
+
+	mu sync.Mutex
+}
+
+// CheckpointFilename is where a new checkpoint for an export of uuid over r
+// is kept inside directory
+func CheckpointFilename(directory string, uuid string, r TimeRange) string {
+	return filepath.Join(directory, fmt.Sprintf(".gonest-checkpoint-%s-%d-%d.json", uuid, r.Start.Unix(), r.End.Unix()))
+}
+
+// FindCheckpoint returns the checkpoint in directory for camera uuid whose
+// range overlaps r, the most recently updated one if there are several, or
+// the filename for a new checkpoint. Ranges such as -24h resolve differently
+// on every run so only an exact match would never resume.
+func FindCheckpoint(directory string, uuid string, r TimeRange) string {
+	found := CheckpointFilename(directory, uuid, r)
+	var updated time.Time
+
+	matches, _ := filepath.Glob(filepath.Join(directory, fmt.Sprintf(".gonest-checkpoint-%s-*.json", uuid)))
+	for _, match := range matches {
+		var c Checkpoint
+		err := golib.LoadFile(match, &c)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"checkpoint": match,
+				"error":      err,
+			}).Warn("failed reading checkpoint")
+			continue
+		}
+		if c.UUID != uuid || !c.End.After(r.Start) || !c.Start.Before(r.End) {
+			continue
+		}
+		if c.Updated.After(updated) {
+			found = match
+			updated = c.Updated
+		}
+	}
+	return found
+}
+
+// LoadCheckpoint reads a checkpoint, a missing file gives an empty checkpoint
+// for uuid and r. A checkpoint saved for another range of the same camera is
+// taken over for r, whatever it completed is skipped by Pending.
+func LoadCheckpoint(filename string, uuid string, r TimeRange) (*Checkpoint, error) {
+	c := &Checkpoint{
+		Filename: filename,
+		UUID:     uuid,
+		Start:    r.Start,
+		End:      r.End,
+	}
+	err := golib.LoadFile(filename, c)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if c.UUID != uuid {
+		return nil, fmt.Errorf("checkpoint %s is for camera %s", filename, c.UUID)
+	}
+	c.Start = r.Start
+	c.End = r.End
+	return c, nil
+}
+
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save()
+}
+
+func (c *Checkpoint) save() error {
+	c.Updated = time.Now()
+	err := mkdirAll(filepath.Dir(c.Filename))
+	if err != nil {
+		return err
+	}
+	return golib.SaveFile(c.Filename, c)
+}
+
+// Remove deletes the checkpoint once the export has finished
+func (c *Checkpoint) Remove() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := os.Remove(c.Filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// completedRanges returns the completed segments whose files are still on
+// disk
+func (c *Checkpoint) completedRanges() []TimeRange {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ranges []TimeRange
+	for _, completed := range c.Completed {
+		if completed.Filename != "" && !FileExists(completed.Filename) {
+			continue
+		}
+		ranges = append(ranges, completed.Range())
+	}
+	return ranges
+}
+
+// Pending returns what still needs exporting of segments. A segment which an
+// earlier run with other segment bounds partly completed is cut down to the
+// missing parts, so nothing is exported twice.
+func (c *Checkpoint) Pending(segments []TimeRange) []TimeRange {
+	completed := c.completedRanges()
+	var pending []TimeRange
+	for _, segment := range segments {
+		for _, gap := range CheckCoverage(segment, completed, 0).Gaps {
+			if gap.Length() >= time.Second {
+				pending = append(pending, gap)
+			}
+		}
+	}
+	return pending
+}
+
+// Segments returns the completed segments within the checkpoint range in
+// order, skipping any that overlap an earlier one unless they were added to
+// fill a gap
+func (c *Checkpoint) Segments() []CheckpointSegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var segments []CheckpointSegment
+	var end time.Time
+	for _, segment := range c.Completed {
+		if !segment.End.After(c.Start) || !segment.Start.Before(c.End) {
+			continue
+		}
+		if !segment.Fill && segment.Start.Before(end) {
+			continue
+		}
+		segments = append(segments, segment)
+		if segment.End.After(end) {
+			end = segment.End
+		}
+	}
+	return segments
+}
+
+// AddFills records segments which were exported separately to fill gaps
+func (c *Checkpoint) AddFills(segments []CheckpointSegment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, segment := range segments {
+		segment.Fill = true
+		c.Completed = append(c.Completed, segment)
+	}
+	sort.SliceStable(c.Completed, func(i, j int) bool {
+		return c.Completed[i].Start.Before(c.Completed[j].Start)
+	})
+	return c.save()
+}
+
+// Leftovers returns the clips a previous run requested but never deleted
+func (c *Checkpoint) Leftovers() []CheckpointSegment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CheckpointSegment(nil), c.InFlight...)
+}
+
+// Started records a requested clip, it is saved immediately so a crash
+// between requesting and deleting the clip can't lose track of it
+func (c *Checkpoint) Started(segment TimeRange, clip *Clip) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeInFlight(clip.ID)
+	c.InFlight = append(c.InFlight, CheckpointSegment{
+		Start:  segment.Start,
+		End:    segment.End,
+		ClipID: clip.ID,
+	})
+	return c.save()
+}
+
+// Finished records the outcome of a segment. Successful segments are marked
+// complete, the clip is forgotten once it has been deleted from the server or
+// was kept on purpose.
+func (c *Checkpoint) Finished(result ExportResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if result.Error == nil {
+		completed := CheckpointSegment{
+			Start:    result.Segment.Start,
+			End:      result.Segment.End,
+			Filename: result.Filename,
+		}
+		if result.Clip != nil {
+			completed.ClipID = result.Clip.ID
+		}
+		c.Completed = append(c.Completed, completed)
+		sort.Slice(c.Completed, func(i, j int) bool {
+			return c.Completed[i].Start.Before(c.Completed[j].Start)
+		})
+	}
+	if result.Clip != nil && (result.Deleted || result.Kept) {
+		c.removeInFlight(result.Clip.ID)
+	}
+	return c.save()
+}
+
+// Forget drops a leftover clip which has been dealt with
+func (c *Checkpoint) Forget(id int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeInFlight(id)
+	return c.save()
+}
+
+func (c *Checkpoint) removeInFlight(id int) {
+	kept := c.InFlight[:0]
+	for _, segment := range c.InFlight {
+		if segment.ClipID != id {
+			kept = append(kept, segment)
+		}
+	}
+	c.InFlight = kept
+}
+
+// RecoverLeftovers looks up the clips an interrupted run left on the server.
+// A leftover is only deleted once completed segments with their files on
+// disk cover its range. Any other leftover is reused for the pending
+// segments it overlaps, which are cut around its range, or left in InFlight
+// on the server when it can't be used. The pending segments are returned
+// along with the clips to reuse keyed by segment start.
+func (n *Nest) RecoverLeftovers(checkpoint *Checkpoint, pending []TimeRange) ([]TimeRange, map[int64]*Clip) {
+	reuse := make(map[int64]*Clip)
+	var reused []TimeRange
+	for _, leftover := range checkpoint.Leftovers() {
+		clip, err := n.GetClip(leftover.ClipID)
+		if err == ErrNotFound {
+			log.WithFields(log.Fields{
+				"id": leftover.ClipID,
+			}).Info("leftover clip is already gone")
+			checkpoint.Forget(leftover.ClipID)
+			continue
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    leftover.ClipID,
+				"error": err,
+			}).Error("failed looking up leftover clip")
+			continue
+		}
+
+		if checkpoint.covered(leftover.Range()) {
+			log.WithFields(log.Fields{
+				"id": clip.ID,
+			}).Info("deleting leftover clip")
+			err = clip.Delete()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"id":    clip.ID,
+					"error": err,
+				}).Error("failed deleting leftover clip")
+				continue
+			}
+			checkpoint.Forget(clip.ID)
+			continue
+		}
+
+		// leftovers overlapping each other can't both be used
+		if !clip.IsError && overlapsAny(leftover.Range(), pending) && !overlapsAny(leftover.Range(), reused) {
+			log.WithFields(log.Fields{
+				"id":      clip.ID,
+				"segment": leftover.Range(),
+			}).Info("reusing leftover clip")
+			pending = cutSegments(pending, leftover.Range())
+			reused = append(reused, leftover.Range())
+			reuse[leftover.Start.Unix()] = clip
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"id":      clip.ID,
+			"segment": leftover.Range(),
+			"link":    clip.PublicLink,
+		}).Warn("leftover clip was never saved, leaving it on the server")
+	}
+	return pending, reuse
+}
+
+// covered reports if completed segments whose files are still on disk cover
+// all of r
+func (c *Checkpoint) covered(r TimeRange) bool {
+	for _, gap := range CheckCoverage(r, c.completedRanges(), 0).Gaps {
+		if gap.Length() >= time.Second {
+			return false
+		}
+	}
+	return true
+}
+
+func overlapsAny(r TimeRange, segments []TimeRange) bool {
+	for _, segment := range segments {
+		if segment.End.After(r.Start) && segment.Start.Before(r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// cutSegments removes r from segments and adds r as a segment of its own,
+// keeping them in start order
+func cutSegments(segments []TimeRange, r TimeRange) []TimeRange {
+	cut := []TimeRange{r}
+	for _, segment := range segments {
+		for _, gap := range CheckCoverage(segment, []TimeRange{r}, 0).Gaps {
+			if gap.Length() >= time.Second {
+				cut = append(cut, gap)
+			}
+		}
+	}
+	sort.Slice(cut, func(i, j int) bool {
+		return cut[i].Start.Before(cut[j].Start)
+	})
+	return cut
+}
@@ -0,0 +1,300 @@
+package gonest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+)
+
+var checkpointBase = time.Unix(1700000000, 0)
+
+// minutes returns the range between start and end minutes past checkpointBase
+func minutes(start, end float64) TimeRange {
+	return TimeRange{
+		Start: checkpointBase.Add(time.Duration(start * float64(time.Minute))),
+		End:   checkpointBase.Add(time.Duration(end * float64(time.Minute))),
+	}
+}
+
+func completedSegment(r TimeRange, filename string) CheckpointSegment {
+	return CheckpointSegment{Start: r.Start, End: r.End, Filename: filename}
+}
+
+func segmentRanges(segments []CheckpointSegment) []TimeRange {
+	var ranges []TimeRange
+	for _, segment := range segments {
+		ranges = append(ranges, segment.Range())
+	}
+	return ranges
+}
+
+func TestCheckpointPending(t *testing.T) {
+	directory := t.TempDir()
+	saved := filepath.Join(directory, "saved.mp4")
+	writeTestFile(t, saved, []byte("video"))
+
+	checkpoint := &Checkpoint{
+		Completed: []CheckpointSegment{
+			completedSegment(minutes(0, 10), saved),
+			// the file has gone since, so the segment is needed again
+			completedSegment(minutes(10, 20), filepath.Join(directory, "gone.mp4")),
+			// no filename is trusted as complete
+			completedSegment(minutes(20, 25), ""),
+			// leaves less than a second of its segment, which isn't requested
+			completedSegment(TimeRange{Start: minutes(30, 40).Start.Add(500 * time.Millisecond), End: minutes(30, 40).End}, saved),
+		},
+	}
+
+	// the segment bounds differ from the run which made the checkpoint
+	pending := checkpoint.Pending([]TimeRange{minutes(0, 15), minutes(15, 30), minutes(30, 40), minutes(40, 45)})
+	expected := []TimeRange{minutes(10, 15), minutes(15, 20), minutes(25, 30), minutes(40, 45)}
+	if !equalRanges(pending, expected) {
+		t.Errorf("got pending %v, expected %v", pending, expected)
+	}
+}
+
+func TestCheckpointSegments(t *testing.T) {
+	checkpoint := &Checkpoint{
+		Start: minutes(0, 60).Start,
+		End:   minutes(0, 60).End,
+		Completed: []CheckpointSegment{
+			completedSegment(minutes(-10, -5), ""),
+			completedSegment(minutes(0, 10), ""),
+			// overlaps the segment before it, from a run with other bounds
+			completedSegment(minutes(5, 15), ""),
+			completedSegment(minutes(10, 20), ""),
+			{Start: minutes(12, 14).Start, End: minutes(12, 14).End, Fill: true},
+			completedSegment(minutes(55, 70), ""),
+			completedSegment(minutes(60, 70), ""),
+		},
+	}
+
+	segments := segmentRanges(checkpoint.Segments())
+	expected := []TimeRange{minutes(0, 10), minutes(10, 20), minutes(12, 14), minutes(55, 70)}
+	if !equalRanges(segments, expected) {
+		t.Errorf("got segments %v, expected %v", segments, expected)
+	}
+}
+
+func TestCheckpointAddFills(t *testing.T) {
+	directory := t.TempDir()
+	r := minutes(0, 30)
+	filename := CheckpointFilename(directory, "abc123", r)
+	checkpoint, err := LoadCheckpoint(filename, "abc123", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Completed = []CheckpointSegment{
+		completedSegment(minutes(0, 10), ""),
+		completedSegment(minutes(20, 30), ""),
+	}
+
+	err = checkpoint.AddFills([]CheckpointSegment{
+		{Start: minutes(10, 20).Start, End: minutes(10, 20).End, ClipID: 5},
+		{Start: minutes(5, 8).Start, End: minutes(5, 8).End, ClipID: 6},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint(filename, "abc123", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fills []bool
+	var ids []int
+	for _, segment := range loaded.Completed {
+		fills = append(fills, segment.Fill)
+		ids = append(ids, segment.ClipID)
+	}
+	if !reflect.DeepEqual(fills, []bool{false, true, true, false}) || !reflect.DeepEqual(ids, []int{0, 6, 5, 0}) {
+		t.Errorf("got saved segments %+v, expected the fills in start order", loaded.Completed)
+	}
+
+	// fills are used even where they overlap another segment
+	segments := segmentRanges(loaded.Segments())
+	expected := []TimeRange{minutes(0, 10), minutes(5, 8), minutes(10, 20), minutes(20, 30)}
+	if !equalRanges(segments, expected) {
+		t.Errorf("got segments %v, expected %v", segments, expected)
+	}
+}
+
+func TestFindCheckpoint(t *testing.T) {
+	directory := t.TempDir()
+	save := func(uuid string, r TimeRange, updated time.Time, completed ...CheckpointSegment) string {
+		filename := CheckpointFilename(directory, uuid, r)
+		err := golib.SaveFile(filename, &Checkpoint{UUID: uuid, Start: r.Start, End: r.End, Completed: completed, Updated: updated})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	older := save("abc123", minutes(0, 60), checkpointBase, completedSegment(minutes(0, 30), ""))
+	newer := save("abc123", minutes(30, 90), checkpointBase.Add(time.Hour), completedSegment(minutes(30, 60), ""))
+	save("abc123", minutes(120, 180), checkpointBase.Add(2*time.Hour))
+	save("other", minutes(0, 180), checkpointBase.Add(3*time.Hour))
+	writeTestFile(t, filepath.Join(directory, ".gonest-checkpoint-abc123-broken.json"), []byte("{"))
+
+	tests := []struct {
+		name     string
+		r        TimeRange
+		expected string
+	}{
+		{
+			name:     "most recent overlapping checkpoint",
+			r:        minutes(45, 105),
+			expected: newer,
+		},
+		{
+			name:     "only overlapping checkpoint",
+			r:        minutes(0, 20),
+			expected: older,
+		},
+		{
+			name:     "touching is not overlapping",
+			r:        minutes(90, 120),
+			expected: CheckpointFilename(directory, "abc123", minutes(90, 120)),
+		},
+		{
+			name:     "other camera",
+			r:        minutes(60, 90),
+			expected: newer,
+		},
+	}
+	for _, test := range tests {
+		found := FindCheckpoint(directory, "abc123", test.r)
+		if found != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, filepath.Base(found), filepath.Base(test.expected))
+		}
+	}
+
+	// the checkpoint found is taken over for the new range and what it
+	// completed is skipped
+	r := minutes(45, 105)
+	checkpoint, err := LoadCheckpoint(FindCheckpoint(directory, "abc123", r), "abc123", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkpoint.Start.Equal(r.Start) || !checkpoint.End.Equal(r.End) {
+		t.Errorf("got range %s - %s, expected %s", checkpoint.Start, checkpoint.End, r)
+	}
+	pending := checkpoint.Pending([]TimeRange{r})
+	if !equalRanges(pending, []TimeRange{minutes(60, 105)}) {
+		t.Errorf("got pending %v, expected the part the checkpoint did not complete", pending)
+	}
+
+	_, err = LoadCheckpoint(newer, "other", r)
+	if err == nil {
+		t.Error("loaded the checkpoint of another camera")
+	}
+}
+
+// seconds returns the range between start and end seconds past checkpointBase
+func seconds(start, end int) TimeRange {
+	return TimeRange{
+		Start: checkpointBase.Add(time.Duration(start) * time.Second),
+		End:   checkpointBase.Add(time.Duration(end) * time.Second),
+	}
+}
+
+func TestRecoverLeftovers(t *testing.T) {
+	directory := t.TempDir()
+	saved := filepath.Join(directory, "saved.mp4")
+	writeTestFile(t, saved, []byte("video"))
+
+	leftover := func(id int, r TimeRange) *Clip {
+		return &Clip{
+			ID:             id,
+			StartTimeFloat: float64(r.Start.Unix()),
+			Length:         r.Length().Seconds(),
+			IsGenerated:    true,
+			DownloadURL:    fmt.Sprintf("https://clips.example/videos/%d.mp4", id),
+		}
+	}
+	errored := leftover(106, seconds(35, 45))
+	errored.IsError = true
+	api, nest := newFakeAPI(t,
+		leftover(101, seconds(0, 10)),
+		leftover(102, seconds(0, 30)),
+		leftover(103, seconds(5, 25)),
+		leftover(104, seconds(100, 110)),
+		errored,
+	)
+	api.video = testVideo(t)
+
+	r := seconds(0, 30)
+	checkpoint, err := LoadCheckpoint(CheckpointFilename(directory, "abc123", r), "abc123", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Completed = []CheckpointSegment{
+		completedSegment(seconds(0, 10), saved),
+		// saved once, but the file is gone
+		completedSegment(seconds(10, 20), filepath.Join(directory, "gone.mp4")),
+	}
+	checkpoint.InFlight = []CheckpointSegment{
+		// covered by a saved segment
+		{Start: seconds(0, 10).Start, End: seconds(0, 10).End, ClipID: 101},
+		// requested by a run with other segment bounds which never saved it
+		{Start: seconds(0, 30).Start, End: seconds(0, 30).End, ClipID: 102},
+		// overlaps 102 which is reused instead
+		{Start: seconds(5, 25).Start, End: seconds(5, 25).End, ClipID: 103},
+		// outside what is pending
+		{Start: seconds(100, 110).Start, End: seconds(100, 110).End, ClipID: 104},
+		// gone from the server
+		{Start: seconds(20, 30).Start, End: seconds(20, 30).End, ClipID: 105},
+		// failed on the server, there is nothing to reuse
+		{Start: seconds(35, 45).Start, End: seconds(35, 45).End, ClipID: 106},
+	}
+
+	pending := checkpoint.Pending([]TimeRange{seconds(0, 20), seconds(20, 30)})
+	pending, reuse := nest.RecoverLeftovers(checkpoint, pending)
+
+	if !equalRanges(pending, []TimeRange{seconds(0, 30)}) {
+		t.Errorf("got pending %v, expected the range of the reused clip", pending)
+	}
+	if len(reuse) != 1 || reuse[seconds(0, 30).Start.Unix()] == nil || reuse[seconds(0, 30).Start.Unix()].ID != 102 {
+		t.Errorf("got reuse %v, expected clip 102", reuse)
+	}
+	api.mu.Lock()
+	deleted := append([]int(nil), api.deleted...)
+	api.mu.Unlock()
+	if !reflect.DeepEqual(deleted, []int{101}) {
+		t.Errorf("deleted %v, expected only the covered clip", deleted)
+	}
+	var ids []int
+	for _, segment := range checkpoint.Leftovers() {
+		ids = append(ids, segment.ClipID)
+	}
+	if !reflect.DeepEqual(ids, []int{102, 103, 104, 106}) {
+		t.Errorf("got leftovers %v, expected every clip which wasn't deleted or gone", ids)
+	}
+
+	// exporting saves the reused clip before deleting it
+	exporter := testExporter(t)
+	exporter.Reuse = reuse
+	exporter.Result = func(result ExportResult) {
+		err := checkpoint.Finished(result)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	results, err := nest.Export(context.Background(), "abc123", pending, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Error != nil || results[0].Clip.ID != 102 || !results[0].Deleted {
+		t.Errorf("got %+v, expected clip 102 saved and deleted", results[0])
+	}
+	if api.count("/api/clips.request") != 0 {
+		t.Error("a clip was requested for a range a leftover holds")
+	}
+	if pending := checkpoint.Pending([]TimeRange{r}); len(pending) != 0 {
+		t.Errorf("got pending %v after the export", pending)
+	}
+}
@@ -1,12 +1,13 @@
 package gonest
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"io/ioutil"
 	"net/url"
-	"os"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -32,6 +33,7 @@ func (c Clip) Delete() error {
 				"url":    url,
 				"status": response.Status,
 			}).Info("clip deleted")
+			c.nest.forgetCreatedClip(c.ID)
 			return nil
 		} else {
 			log.WithFields(log.Fields{
@@ -44,112 +46,87 @@ func (c Clip) Delete() error {
 }
 
 func (c Clip) Save(filename string) error {
-	tmpFilename := fmt.Sprintf("%s.tmp", filename)
+	return c.SaveWithOptions(filename, SaveOptions{})
+}
 
-	fh, err := os.Create(tmpFilename)
+// SaveWithOptions downloads the clip to filename. Metadata is embedded before
+// the video is moved into place and the sidecar is written last, so a crash
+// part way never leaves a video which looks finished without them.
+func (c Clip) SaveWithOptions(filename string, opts SaveOptions) error {
+	var prepare func(string) error
+	if opts.EmbedMetadata {
+		prepare = func(tmpFilename string) error {
+			return c.embedMetadata(tmpFilename, opts.logger())
+		}
+	}
+	err := c.saveURL(c.DownloadURL, filename, c.Verify, prepare, opts)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-			"id":    c.ID,
-			"url":   c.DownloadURL,
-		}).Error("failed open file for clip download")
 		return err
 	}
+	if opts.Sidecar {
+		return c.writeSidecar(filename, opts.logger())
+	}
+	return nil
+}
 
-	attempts := 0
-	for {
-		request, err := http.NewRequest("GET", c.DownloadURL, nil)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"id":    c.ID,
-				"url":   c.DownloadURL,
-			}).Error("failed create new HTTP request for clip download")
-			return err
-		}
+func (c Clip) SaveThumbnail(filename string) error {
+	return c.saveThumbnail(filename, SaveOptions{})
+}
 
-		request.Header.Add("Cookie", fmt.Sprintf("cztoken=%s; user_token=%s", c.nest.CZToken, c.nest.UserToken))
+func (c Clip) saveThumbnail(filename string, opts SaveOptions) error {
+	if c.ThumbnailURL == "" {
+		return errors.New("clip has no thumbnail")
+	}
+	return c.saveURL(c.ThumbnailURL, filename, nil, nil, opts)
+}
 
-		response, err := c.nest.httpClient.Do(request)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"error": err,
-				"id":    c.ID,
-				"url":   c.DownloadURL,
-			}).Error("failed to fetch clip")
-			return err
-		}
-		defer response.Body.Close()
+func (c Clip) Thumbnail() ([]byte, error) {
+	if c.ThumbnailURL == "" {
+		return nil, errors.New("clip has no thumbnail")
+	}
 
-		if response.StatusCode == 403 {
-			return errors.New("unable to save clip: 403")
-		}
+	response, err := c.fetch(context.Background(), c.ThumbnailURL, nil, nil, log.StandardLogger())
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
 
-		if response.StatusCode == 404 {
-			log.WithFields(log.Fields{
-				"status":   response.Status,
-				"id":       c.ID,
-				"url":      c.DownloadURL,
-				"attempts": attempts,
-			}).Info("waiting for file")
-			attempts += 1
-			if attempts > 300 {
-				return errors.New("unable to save clip: clip not processed after 300 seconds")
-			}
-			time.Sleep(time.Second)
-			continue
-		}
+	return ioutil.ReadAll(response.Body)
+}
 
-		if response.StatusCode != 200 {
-			log.WithFields(log.Fields{
-				"status":   response.Status,
-				"id":       c.ID,
-				"url":      c.DownloadURL,
-				"attempts": attempts,
-			}).Info("hopefully temporary error fetching clip")
-			attempts += 1
-			if attempts > 300 {
-				return errors.New("unable to save clip: too many errors")
-			}
-			time.Sleep(time.Second)
-			continue
-		}
+// Open returns the clip video and its size, which is -1 when the server does
+// not say. The caller must close the returned reader.
+func (c Clip) Open(ctx context.Context) (io.ReadCloser, int64, error) {
+	return c.OpenWithOptions(ctx, SaveOptions{})
+}
 
-		log.WithFields(log.Fields{
-			"id":       c.ID,
-			"filename": filename,
-			"url":      c.DownloadURL,
-		}).Info("saving file")
-		_, err = io.Copy(fh, response.Body)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"id":          c.ID,
-				"filename":    filename,
-				"tmpFilename": tmpFilename,
-				"error":       err,
-				"url":         c.DownloadURL,
-			}).Info("saving file failed")
-			response.Body.Close()
-			return err
-		}
-		response.Body.Close()
+// OpenWithOptions is Open with the RateLimit and Log of opts applied, the
+// other options only matter when saving to disk
+func (c Clip) OpenWithOptions(ctx context.Context, opts SaveOptions) (io.ReadCloser, int64, error) {
+	response, err := c.fetch(ctx, c.DownloadURL, nil, nil, opts.logger())
+	if err != nil {
+		return nil, 0, err
+	}
+	body := limitedReadCloser{
+		Reader: opts.limit(ctx, response.Body),
+		Closer: response.Body,
+	}
+	return body, response.ContentLength, nil
+}
 
-		err = os.Rename(tmpFilename, filename)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"id":          c.ID,
-				"filename":    filename,
-				"tmpFilename": tmpFilename,
-				"error":       err,
-				"url":         c.DownloadURL,
-			}).Info("renaming file failed")
-			response.Body.Close()
-			return err
-		}
-		break
+// WriteTo streams the clip video to w without touching the local disk
+func (c Clip) WriteTo(w io.Writer) (int64, error) {
+	return c.WriteToWithOptions(w, SaveOptions{})
+}
+
+func (c Clip) WriteToWithOptions(w io.Writer, opts SaveOptions) (int64, error) {
+	body, _, err := c.OpenWithOptions(context.Background(), opts)
+	if err != nil {
+		return 0, err
 	}
+	defer body.Close()
 
-	return nil
+	return io.Copy(w, body)
 }
 
 /*
@@ -184,6 +161,7 @@ func (c Clip) Save(filename string) error {
 */
 type Clip struct {
 	nest *Nest
+	raw  json.RawMessage
 
 	PublicLink            string  `json:"public_link"`
 	DownloadURL           string  `json:"download_url"`
@@ -193,6 +171,22 @@ type Clip struct {
 	GeneratedtedTimeFloat float64 `json:"generated_time"`
 	StartTimeFloat        float64 `json:"start_time"`
 	Filename              string  `json:"filename"`
+	ThumbnailURL          string  `json:"thumbnail_url"`
+	CameraUUID            string  `json:"camera_uuid"`
+	IsGenerated           bool    `json:"is_generated"`
+	IsError               bool    `json:"is_error"`
+}
+
+func (c Clip) GeneratedTime() time.Time {
+	return floatTime(c.GeneratedtedTimeFloat)
+}
+
+func (c Clip) StartTime() time.Time {
+	return floatTime(c.StartTimeFloat)
+}
+
+func floatTime(f float64) time.Time {
+	return time.Unix(0, int64(f*float64(time.Second)))
 }
 
 type Items struct {
@@ -213,6 +207,18 @@ type ClipCreateResponse struct {
 	StatusDetail      string  `json:"status_detail"`
 }
 
+// APIError is a request the server understood and refused, such as a clip
+// request beyond the clip quota
+type APIError struct {
+	Status      int
+	Description string
+	Detail      string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d: %s: %s", e.Status, e.Description, e.Detail)
+}
+
 // https://webapi.camera.home.nest.com/api/clips.get_visible_with_quota
 // https://home.nest.com/dropcam/api/visible_clips
 func (n *Nest) ListClips() ([]*Clip, error) {
@@ -233,6 +239,46 @@ func (n *Nest) ListClips() ([]*Clip, error) {
 	return clipList, nil
 }
 
+var ErrNotFound = errors.New("clip not found")
+
+type ClipGetResponse struct {
+	Clips             []*Clip `json:"items"`
+	Status            int     `json:"status"`
+	StatusDescription string  `json:"status_description"`
+	StatusDetail      string  `json:"status_detail"`
+}
+
+// https://webapi.camera.home.nest.com/api/clips.get?id=442909
+func (n *Nest) GetClip(id int) (*Clip, error) {
+	var clipResponse ClipGetResponse
+	err := n.GetJSONUnmarsahl(fmt.Sprintf("https://webapi.camera.home.nest.com/api/clips.get?id=%d", id), &clipResponse)
+	if err == nil && clipResponse.Status == 0 {
+		for _, clip := range clipResponse.Clips {
+			if clip.ID == id {
+				clip.nest = n
+				return clip, nil
+			}
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":    id,
+		"error": err,
+	}).Debug("single clip lookup failed, falling back to clip list")
+
+	clips, err := n.ListClips()
+	if err != nil {
+		return nil, err
+	}
+	for _, clip := range clips {
+		if clip.ID == id {
+			return clip, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
 // https://home.nest.com/camera/50f668e4151745988da09a704458d7f6/clips
 func (n *Nest) CreateClip(uuid string, start time.Time, length int) (*Clip, error) {
 	form := url.Values{}
@@ -252,7 +298,11 @@ func (n *Nest) CreateClip(uuid string, start time.Time, length int) (*Clip, erro
 	}
 
 	if clipResponse.Status > 0 {
-		return nil, fmt.Errorf("%d: %s: %s", clipResponse.Status, clipResponse.StatusDescription, clipResponse.StatusDetail)
+		return nil, &APIError{
+			Status:      clipResponse.Status,
+			Description: clipResponse.StatusDescription,
+			Detail:      clipResponse.StatusDetail,
+		}
 	}
 
 	if len(clipResponse.Clips) == 0 {
@@ -262,6 +312,10 @@ func (n *Nest) CreateClip(uuid string, start time.Time, length int) (*Clip, erro
 	clip := clipResponse.Clips[0]
 	clip.nest = n
 
+	n.mu.Lock()
+	n.CreatedClips = append(n.CreatedClips, clip.ID)
+	n.mu.Unlock()
+
 	return clip, nil
 
 }
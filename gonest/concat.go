@@ -0,0 +1,732 @@
+package gonest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrConcatIncompatible = errors.New("mp4 files can not be joined")
+
+type ConcatInput struct {
+	Filename string
+	// Title names the chapter which starts at this input
+	Title string
+}
+
+// concatSource is an input file with its moov parsed and its media data
+// located
+type concatSource struct {
+	fh     *os.File
+	ftyp   []byte
+	moov   *atom
+	mdats  []mp4Box
+	tracks []*trackTables
+}
+
+// trackTables are the sample tables of one track, expanded so they can be
+// appended to each other
+type trackTables struct {
+	trak        *atom
+	handler     string
+	timescale   uint32
+	duration    uint64
+	entries     [][]byte
+	stsdHeader  []byte
+	stts        [][2]uint32
+	ctts        [][2]uint32
+	cttsVersion byte
+	hasCtts     bool
+	sizes       []uint32
+	stsc        [][3]uint32
+	chunks      []uint64
+	sync        []uint32
+	hasSync     bool
+}
+
+// ConcatMP4 losslessly joins inputs into output by appending their media
+// data and sample tables, without decoding anything. Every input needs the
+// same tracks with the same timescales, and every input but the last needs
+// tracks which end together. Edit lists are dropped and the start of every
+// input is recorded as a Nero style chapter.
+func ConcatMP4(output string, inputs []ConcatInput) error {
+	if len(inputs) == 0 {
+		return errors.New("nothing to join")
+	}
+
+	var sources []*concatSource
+	defer func() {
+		for _, source := range sources {
+			source.fh.Close()
+		}
+	}()
+	for _, input := range inputs {
+		source, err := openConcatSource(input.Filename)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.Filename, err)
+		}
+		sources = append(sources, source)
+	}
+
+	// the last input has nothing appended after it, so it may drift
+	for _, source := range sources[:len(sources)-1] {
+		err := checkTrackDrift(source)
+		if err != nil {
+			return err
+		}
+	}
+
+	first := sources[0]
+	for _, source := range sources[1:] {
+		if len(source.tracks) != len(first.tracks) {
+			return fmt.Errorf("%w: %s has %d tracks, expected %d", ErrConcatIncompatible, source.fh.Name(), len(source.tracks), len(first.tracks))
+		}
+		for i, track := range source.tracks {
+			if track.handler != first.tracks[i].handler || track.timescale != first.tracks[i].timescale {
+				return fmt.Errorf("%w: track %d of %s is %s at %d, expected %s at %d", ErrConcatIncompatible, i+1, source.fh.Name(), track.handler, track.timescale, first.tracks[i].handler, first.tracks[i].timescale)
+			}
+		}
+	}
+
+	movieTimescale, _, err := parseMvhd(first.moov.Child("mvhd").Data)
+	if err != nil {
+		return err
+	}
+
+	// lay the media data of every input out back to back, chunk offsets are
+	// relative to the start of the joined mdat payload until the moov size
+	// is known
+	var mdatSize int64
+	bases := make([][]int64, len(sources))
+	for i, source := range sources {
+		for _, mdat := range source.mdats {
+			bases[i] = append(bases[i], mdatSize)
+			mdatSize += mdat.DataSize()
+		}
+	}
+
+	moov := &atom{Type: "moov", Children: cloneAtoms(first.moov.Children)}
+	var movieDuration uint64
+	var chapterStarts []time.Duration
+	for t := range first.tracks {
+		merged, starts, err := mergeTracks(sources, bases, t)
+		if err != nil {
+			return err
+		}
+		if t == 0 {
+			chapterStarts = starts
+		}
+
+		// moov is a copy of the first moov, so its traks sit at the same index
+		trak := moov.Children[indexOf(first.moov.Children, first.tracks[t].trak)]
+		trak.Remove("edts")
+		err = replaceSampleTables(trak, merged)
+		if err != nil {
+			return err
+		}
+
+		err = setHeaderDuration(trak.Path("mdia", "mdhd"), merged.duration)
+		if err != nil {
+			return err
+		}
+		trackDuration := merged.duration * uint64(movieTimescale) / uint64(merged.timescale)
+		err = setHeaderDuration(trak.Child("tkhd"), trackDuration)
+		if err != nil {
+			return err
+		}
+		if trackDuration > movieDuration {
+			movieDuration = trackDuration
+		}
+	}
+	err = setHeaderDuration(moov.Child("mvhd"), movieDuration)
+	if err != nil {
+		return err
+	}
+
+	udta := moov.Child("udta")
+	if udta == nil {
+		udta = &atom{Type: "udta", Children: []*atom{}}
+		moov.Children = append(moov.Children, udta)
+	}
+	udta.Remove("chpl")
+	udta.Children = append(udta.Children, chapterAtom(inputs, chapterStarts))
+
+	mdatHeader := int64(8)
+	if mdatSize+8 > math.MaxUint32 {
+		mdatHeader = 16
+	}
+	// moving the offsets can turn stco into co64 which grows the moov again
+	for i := 0; ; i++ {
+		delta := int64(len(first.ftyp)) + moov.Size() + mdatHeader
+		shifted := &atom{Type: "moov", Children: cloneAtoms(moov.Children)}
+		err = shifted.shiftChunkOffsets(delta)
+		if err != nil {
+			return err
+		}
+		if int64(len(first.ftyp))+shifted.Size()+mdatHeader == delta {
+			moov = shifted
+			break
+		}
+		if i > 2 {
+			return errors.New("unable to settle moov size")
+		}
+		moov.upgradeChunkOffsets()
+	}
+
+	return writeConcat(output, first.ftyp, moov, mdatHeader, mdatSize, sources)
+}
+
+func openConcatSource(filename string) (*concatSource, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	source := &concatSource{fh: fh}
+
+	stat, err := fh.Stat()
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	boxes, err := readBoxes(fh, 0, stat.Size())
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	for _, box := range boxes {
+		switch box.Type {
+		case "ftyp":
+			source.ftyp = make([]byte, box.Size)
+			_, err = fh.ReadAt(source.ftyp, box.Offset)
+		case "moov":
+			data := make([]byte, box.DataSize())
+			_, err = fh.ReadAt(data, box.DataOffset())
+			if err == nil {
+				var children []*atom
+				children, err = parseAtoms(data)
+				source.moov = &atom{Type: "moov", Children: children}
+			}
+		case "mdat":
+			source.mdats = append(source.mdats, box)
+		case "moof":
+			err = fmt.Errorf("%w: fragmented mp4", ErrConcatIncompatible)
+		}
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+	}
+	if source.ftyp == nil || source.moov == nil || source.moov.Child("mvhd") == nil {
+		fh.Close()
+		return nil, fmt.Errorf("%w: missing ftyp, moov or mvhd box", ErrNotMP4)
+	}
+
+	for _, trak := range source.moov.Children {
+		if trak.Type != "trak" {
+			continue
+		}
+		track, err := readTrackTables(trak)
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+		source.tracks = append(source.tracks, track)
+	}
+	if len(source.tracks) == 0 {
+		fh.Close()
+		return nil, fmt.Errorf("%w: no tracks", ErrNotMP4)
+	}
+	return source, nil
+}
+
+func readTrackTables(trak *atom) (*trackTables, error) {
+	mdhd := trak.Path("mdia", "mdhd")
+	hdlr := trak.Path("mdia", "hdlr")
+	stbl := trak.Path("mdia", "minf", "stbl")
+	if mdhd == nil || hdlr == nil || stbl == nil || len(hdlr.Data) < 12 {
+		return nil, fmt.Errorf("%w: incomplete trak box", ErrNotMP4)
+	}
+
+	// mdhd shares its timescale and duration layout with mvhd
+	timescale, duration, err := parseMvhd(mdhd.Data)
+	if err != nil {
+		return nil, err
+	}
+	track := &trackTables{
+		trak:      trak,
+		handler:   string(hdlr.Data[8:12]),
+		timescale: timescale,
+		duration:  duration,
+	}
+
+	stsd := stbl.Child("stsd")
+	if stsd == nil || len(stsd.Data) < 8 {
+		return nil, fmt.Errorf("%w: missing stsd box", ErrNotMP4)
+	}
+	track.stsdHeader = stsd.Data[0:4]
+	entries, err := readBoxes(bytes.NewReader(stsd.Data), 8, int64(len(stsd.Data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		track.entries = append(track.entries, stsd.Data[entry.Offset:entry.Offset+entry.Size])
+	}
+
+	stts, err := tableEntries(stbl.Child("stts"), 2)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range stts {
+		track.stts = append(track.stts, [2]uint32{entry[0], entry[1]})
+	}
+
+	if ctts := stbl.Child("ctts"); ctts != nil {
+		entries, err := tableEntries(ctts, 2)
+		if err != nil {
+			return nil, err
+		}
+		track.hasCtts = true
+		track.cttsVersion = ctts.Data[0]
+		for _, entry := range entries {
+			track.ctts = append(track.ctts, [2]uint32{entry[0], entry[1]})
+		}
+	}
+
+	stsc, err := tableEntries(stbl.Child("stsc"), 3)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range stsc {
+		track.stsc = append(track.stsc, [3]uint32{entry[0], entry[1], entry[2]})
+	}
+
+	stsz := stbl.Child("stsz")
+	if stsz == nil || len(stsz.Data) < 12 {
+		return nil, fmt.Errorf("%w: missing stsz box", ErrNotMP4)
+	}
+	sampleSize := binary.BigEndian.Uint32(stsz.Data[4:8])
+	sampleCount := int(binary.BigEndian.Uint32(stsz.Data[8:12]))
+	if sampleSize == 0 && len(stsz.Data) < 12+sampleCount*4 {
+		return nil, fmt.Errorf("%w: short stsz box", ErrNotMP4)
+	}
+	track.sizes = make([]uint32, sampleCount)
+	for i := range track.sizes {
+		if sampleSize != 0 {
+			track.sizes[i] = sampleSize
+		} else {
+			track.sizes[i] = binary.BigEndian.Uint32(stsz.Data[12+i*4:])
+		}
+	}
+
+	chunks := stbl.Child("stco")
+	if chunks == nil {
+		chunks = stbl.Child("co64")
+	}
+	if chunks == nil {
+		return nil, fmt.Errorf("%w: missing stco box", ErrNotMP4)
+	}
+	track.chunks, err = chunkOffsets(chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	if stss := stbl.Child("stss"); stss != nil {
+		entries, err := tableEntries(stss, 1)
+		if err != nil {
+			return nil, err
+		}
+		track.hasSync = true
+		for _, entry := range entries {
+			track.sync = append(track.sync, entry[0])
+		}
+	}
+	return track, nil
+}
+
+// tableEntries reads a full box made of a count and fixed width uint32 rows
+func tableEntries(a *atom, width int) ([][]uint32, error) {
+	if a == nil {
+		return nil, fmt.Errorf("%w: missing sample table", ErrNotMP4)
+	}
+	if len(a.Data) < 8 {
+		return nil, fmt.Errorf("%w: short %s box", ErrNotMP4, a.Type)
+	}
+	count := int(binary.BigEndian.Uint32(a.Data[4:8]))
+	if len(a.Data) < 8+count*width*4 {
+		return nil, fmt.Errorf("%w: short %s box", ErrNotMP4, a.Type)
+	}
+	rows := make([][]uint32, count)
+	for i := range rows {
+		rows[i] = make([]uint32, width)
+		for j := range rows[i] {
+			rows[i][j] = binary.BigEndian.Uint32(a.Data[8+(i*width+j)*4:])
+		}
+	}
+	return rows, nil
+}
+
+// mergeTracks appends track t of every source, it also returns where each
+// source starts on the track's timeline
+func mergeTracks(sources []*concatSource, bases [][]int64, t int) (*trackTables, []time.Duration, error) {
+	merged := &trackTables{
+		handler:    sources[0].tracks[t].handler,
+		timescale:  sources[0].tracks[t].timescale,
+		stsdHeader: sources[0].tracks[t].stsdHeader,
+	}
+	for _, source := range sources {
+		if source.tracks[t].hasCtts {
+			merged.hasCtts = true
+			if source.tracks[t].cttsVersion > merged.cttsVersion {
+				merged.cttsVersion = source.tracks[t].cttsVersion
+			}
+		}
+		if source.tracks[t].hasSync {
+			merged.hasSync = true
+		}
+	}
+
+	var starts []time.Duration
+	for s, source := range sources {
+		track := source.tracks[t]
+		starts = append(starts, time.Duration(float64(merged.duration)/float64(merged.timescale)*float64(time.Second)))
+
+		// sample descriptions are shared when identical, otherwise appended
+		descriptions := make(map[uint32]uint32)
+		for i, entry := range track.entries {
+			index := -1
+			for j, existing := range merged.entries {
+				if bytes.Equal(existing, entry) {
+					index = j
+				}
+			}
+			if index == -1 {
+				merged.entries = append(merged.entries, entry)
+				index = len(merged.entries) - 1
+			}
+			descriptions[uint32(i+1)] = uint32(index + 1)
+		}
+
+		sampleBase := uint32(len(merged.sizes))
+		chunkBase := uint32(len(merged.chunks))
+
+		for _, entry := range track.stts {
+			last := len(merged.stts) - 1
+			if last >= 0 && merged.stts[last][1] == entry[1] {
+				merged.stts[last][0] += entry[0]
+			} else {
+				merged.stts = append(merged.stts, entry)
+			}
+		}
+		if merged.hasCtts {
+			if track.hasCtts {
+				merged.ctts = append(merged.ctts, track.ctts...)
+			} else if len(track.sizes) > 0 {
+				merged.ctts = append(merged.ctts, [2]uint32{uint32(len(track.sizes)), 0})
+			}
+		}
+		for _, entry := range track.stsc {
+			description, ok := descriptions[entry[2]]
+			if !ok {
+				return nil, nil, fmt.Errorf("%w: stsc refers to missing sample description %d", ErrNotMP4, entry[2])
+			}
+			merged.stsc = append(merged.stsc, [3]uint32{entry[0] + chunkBase, entry[1], description})
+		}
+		merged.sizes = append(merged.sizes, track.sizes...)
+		if merged.hasSync {
+			if track.hasSync {
+				for _, sample := range track.sync {
+					merged.sync = append(merged.sync, sample+sampleBase)
+				}
+			} else {
+				for i := range track.sizes {
+					merged.sync = append(merged.sync, uint32(i+1)+sampleBase)
+				}
+			}
+		}
+
+		for _, offset := range track.chunks {
+			moved := false
+			for m, mdat := range source.mdats {
+				if int64(offset) >= mdat.DataOffset() && int64(offset) < mdat.Offset+mdat.Size {
+					merged.chunks = append(merged.chunks, uint64(bases[s][m]+int64(offset)-mdat.DataOffset()))
+					moved = true
+					break
+				}
+			}
+			if !moved {
+				return nil, nil, fmt.Errorf("%w: chunk at %d of %s is outside the media data", ErrNotMP4, offset, source.fh.Name())
+			}
+		}
+
+		// the next input starts where these samples end, which is not always
+		// what mdhd claims
+		merged.duration += track.sampleDuration()
+	}
+	return merged, starts, nil
+}
+
+// sampleDuration is how long the samples of the track last in its timescale,
+// falling back to the mdhd duration for a track without any
+func (t *trackTables) sampleDuration() uint64 {
+	var duration uint64
+	for _, entry := range t.stts {
+		duration += uint64(entry[0]) * uint64(entry[1])
+	}
+	if duration == 0 {
+		return t.duration
+	}
+	return duration
+}
+
+// checkTrackDrift refuses a source whose tracks end more than a sample apart.
+// Each track of the next input is appended where the same track of this one
+// ends, so the difference would put the tracks out of sync from there on.
+func checkTrackDrift(source *concatSource) error {
+	seconds := func(units uint64, timescale uint32) time.Duration {
+		return time.Duration(float64(units) / float64(timescale) * float64(time.Second))
+	}
+	var shortest, longest, frame time.Duration
+	for i, track := range source.tracks {
+		duration := seconds(track.sampleDuration(), track.timescale)
+		if i == 0 || duration < shortest {
+			shortest = duration
+		}
+		if duration > longest {
+			longest = duration
+		}
+		for _, entry := range track.stts {
+			if sample := seconds(uint64(entry[1]), track.timescale); sample > frame {
+				frame = sample
+			}
+		}
+	}
+	// a little slack for durations which don't divide into nanoseconds
+	if longest-shortest > frame+time.Microsecond {
+		return fmt.Errorf("%w: tracks of %s end %s apart, joining would put them out of sync", ErrConcatIncompatible, source.fh.Name(), longest-shortest)
+	}
+	return nil
+}
+
+// replaceSampleTables swaps the stbl of trak for one built from tables,
+// anything the merge doesn't understand, such as sample groups, is dropped
+func replaceSampleTables(trak *atom, tables *trackTables) error {
+	minf := trak.Path("mdia", "minf")
+	if minf == nil {
+		return fmt.Errorf("%w: missing minf box", ErrNotMP4)
+	}
+
+	stsd := append([]byte(nil), tables.stsdHeader...)
+	stsd = appendUint32(stsd, uint32(len(tables.entries)))
+	for _, entry := range tables.entries {
+		stsd = append(stsd, entry...)
+	}
+
+	stbl := &atom{Type: "stbl", Children: []*atom{{Type: "stsd", Data: stsd}}}
+
+	stts := appendUint32(make([]byte, 4), uint32(len(tables.stts)))
+	for _, entry := range tables.stts {
+		stts = appendUint32(appendUint32(stts, entry[0]), entry[1])
+	}
+	stbl.Children = append(stbl.Children, &atom{Type: "stts", Data: stts})
+
+	if tables.hasCtts {
+		ctts := appendUint32([]byte{tables.cttsVersion, 0, 0, 0}, uint32(len(tables.ctts)))
+		for _, entry := range tables.ctts {
+			ctts = appendUint32(appendUint32(ctts, entry[0]), entry[1])
+		}
+		stbl.Children = append(stbl.Children, &atom{Type: "ctts", Data: ctts})
+	}
+
+	stsc := appendUint32(make([]byte, 4), uint32(len(tables.stsc)))
+	for _, entry := range tables.stsc {
+		stsc = appendUint32(appendUint32(appendUint32(stsc, entry[0]), entry[1]), entry[2])
+	}
+	stbl.Children = append(stbl.Children, &atom{Type: "stsc", Data: stsc})
+
+	constant := len(tables.sizes) > 0
+	for _, size := range tables.sizes {
+		if size != tables.sizes[0] {
+			constant = false
+			break
+		}
+	}
+	stsz := make([]byte, 4)
+	if constant {
+		stsz = appendUint32(appendUint32(stsz, tables.sizes[0]), uint32(len(tables.sizes)))
+	} else {
+		stsz = appendUint32(appendUint32(stsz, 0), uint32(len(tables.sizes)))
+		for _, size := range tables.sizes {
+			stsz = appendUint32(stsz, size)
+		}
+	}
+	stbl.Children = append(stbl.Children, &atom{Type: "stsz", Data: stsz})
+
+	chunks := &atom{Type: "stco"}
+	setChunkOffsets(chunks, tables.chunks)
+	stbl.Children = append(stbl.Children, chunks)
+
+	if tables.hasSync {
+		stss := appendUint32(make([]byte, 4), uint32(len(tables.sync)))
+		for _, sample := range tables.sync {
+			stss = appendUint32(stss, sample)
+		}
+		stbl.Children = append(stbl.Children, &atom{Type: "stss", Data: stss})
+	}
+
+	minf.Children[indexOf(minf.Children, minf.Child("stbl"))] = stbl
+	return nil
+}
+
+func indexOf(atoms []*atom, a *atom) int {
+	for i, candidate := range atoms {
+		if candidate == a {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// setHeaderDuration sets the duration of an mvhd, mdhd or tkhd box,
+// switching to version 1 when it no longer fits in 32 bits
+func setHeaderDuration(a *atom, duration uint64) error {
+	if a == nil {
+		return fmt.Errorf("%w: missing header box", ErrNotMP4)
+	}
+	// mvhd and mdhd have a timescale before the duration, tkhd a track id
+	// and a reserved field
+	skip := 4
+	if a.Type == "tkhd" {
+		skip = 8
+	}
+	data := a.Data
+	if len(data) < 16+skip || (data[0] == 1 && len(data) < 28+skip) {
+		return fmt.Errorf("%w: short %s box", ErrNotMP4, a.Type)
+	}
+	if data[0] == 0 && duration > math.MaxUint32 {
+		upgraded := []byte{1, data[1], data[2], data[3]}
+		upgraded = appendUint64(upgraded, uint64(binary.BigEndian.Uint32(data[4:8])))
+		upgraded = appendUint64(upgraded, uint64(binary.BigEndian.Uint32(data[8:12])))
+		upgraded = append(upgraded, data[12:12+skip]...)
+		upgraded = appendUint64(upgraded, 0)
+		upgraded = append(upgraded, data[16+skip:]...)
+		data = upgraded
+	}
+	if data[0] == 0 {
+		binary.BigEndian.PutUint32(data[12+skip:], uint32(duration))
+	} else {
+		binary.BigEndian.PutUint64(data[20+skip:], duration)
+	}
+	a.Data = data
+	return nil
+}
+
+// chapterAtom builds a Nero chpl box, which most players show as chapters.
+// Times are in 100ns units and there is room for 255 chapters.
+func chapterAtom(inputs []ConcatInput, starts []time.Duration) *atom {
+	count := len(starts)
+	if count > 255 {
+		count = 255
+	}
+	data := []byte{1, 0, 0, 0, 0, 0, 0, 0, byte(count)}
+	for i := 0; i < count; i++ {
+		title := inputs[i].Title
+		if title == "" {
+			title = filepath.Base(inputs[i].Filename)
+		}
+		if len(title) > 255 {
+			title = title[:255]
+		}
+		data = appendUint64(data, uint64(starts[i]/100))
+		data = append(data, byte(len(title)))
+		data = append(data, title...)
+	}
+	return &atom{Type: "chpl", Data: data}
+}
+
+func writeConcat(output string, ftyp []byte, moov *atom, mdatHeader int64, mdatSize int64, sources []*concatSource) error {
+	err := mkdirAll(filepath.Dir(output))
+	if err != nil {
+		return err
+	}
+
+	partial := fmt.Sprintf("%s.tmp", output)
+	out, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial)
+	defer out.Close()
+
+	_, err = out.Write(ftyp)
+	if err != nil {
+		return err
+	}
+	_, err = moov.WriteTo(out)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, mdatHeader)
+	if mdatHeader == 16 {
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		binary.BigEndian.PutUint64(header[8:16], uint64(mdatSize+16))
+	} else {
+		binary.BigEndian.PutUint32(header[0:4], uint32(mdatSize+8))
+	}
+	copy(header[4:8], "mdat")
+	_, err = out.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		for _, mdat := range source.mdats {
+			_, err = io.Copy(out, io.NewSectionReader(source.fh, mdat.DataOffset(), mdat.DataSize()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = out.Sync()
+	if err != nil {
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(partial, output)
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(output))
+
+	log.WithFields(log.Fields{
+		"output": output,
+		"inputs": len(sources),
+		"size":   int64(len(ftyp)) + moov.Size() + mdatHeader + mdatSize,
+	}).Info("joined mp4 files")
+	return nil
+}
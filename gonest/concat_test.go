@@ -0,0 +1,382 @@
+package gonest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testInput struct {
+	Title     string
+	MdatFirst bool
+	Tracks    []testTrack
+	// Raw replaces the generated file
+	Raw []byte
+}
+
+func videoTrack(fill byte, sizes ...uint32) testTrack {
+	return testTrack{Handler: "vide", Timescale: 90000, Delta: 3000, Sizes: sizes, Sync: []uint32{1}, Fill: fill}
+}
+
+func audioTrack(fill byte, sizes ...uint32) testTrack {
+	return testTrack{Handler: "soun", Timescale: 48000, Delta: 1600, Sizes: sizes, Entry: testBox("mp4a", make([]byte, 8)), Fill: fill}
+}
+
+type testChapter struct {
+	Start time.Duration
+	Title string
+}
+
+func readChapters(t *testing.T, moov *atom) []testChapter {
+	t.Helper()
+	chpl := moov.Path("udta", "chpl")
+	if chpl == nil {
+		t.Fatal("missing chpl box")
+	}
+	data := chpl.Data
+	var chapters []testChapter
+	offset := 9
+	for i := 0; i < int(data[8]); i++ {
+		start := time.Duration(binary.BigEndian.Uint64(data[offset:])) * 100
+		length := int(data[offset+8])
+		chapters = append(chapters, testChapter{Start: start, Title: string(data[offset+9 : offset+9+length])})
+		offset += 9 + length
+	}
+	return chapters
+}
+
+func TestConcatMP4(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []testInput
+		// sync and entries are expected for the first track
+		sync     []uint32
+		entries  int
+		duration time.Duration
+		chapters []testChapter
+		err      error
+	}{
+		{
+			name: "two inputs",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30)}},
+				{Title: "second", Tracks: []testTrack{videoTrack('b', 40, 50)}},
+			},
+			sync:     []uint32{1, 4},
+			entries:  1,
+			duration: 166 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "co64 input with the mdat first",
+			inputs: []testInput{
+				{Title: "first", MdatFirst: true, Tracks: []testTrack{func() testTrack {
+					track := videoTrack('a', 10, 20, 30)
+					track.Co64 = true
+					return track
+				}()}},
+				{Title: "second", Tracks: []testTrack{videoTrack('b', 40, 50)}},
+				{Title: "third", MdatFirst: true, Tracks: []testTrack{videoTrack('c', 60)}},
+			},
+			sync:     []uint32{1, 4, 6},
+			entries:  1,
+			duration: 200 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}, {166666600, "third"}},
+		},
+		{
+			name: "video and audio",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30), audioTrack('x', 5, 5, 5)}},
+				{Title: "second", Tracks: []testTrack{videoTrack('b', 40, 50), audioTrack('y', 6, 6)}},
+			},
+			sync:     []uint32{1, 4},
+			entries:  1,
+			duration: 166 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "tracks a frame apart",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30), audioTrack('x', 5, 5)}},
+				{Title: "second", Tracks: []testTrack{videoTrack('b', 40, 50), audioTrack('y', 6, 6)}},
+			},
+			sync:     []uint32{1, 4},
+			entries:  1,
+			duration: 166 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "tracks of the last input apart",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30), audioTrack('x', 5, 5, 5)}},
+				{Title: "second", Tracks: []testTrack{videoTrack('b', 40, 50, 60, 70), audioTrack('y', 6)}},
+			},
+			sync:     []uint32{1, 4},
+			entries:  1,
+			duration: 233 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "tracks more than a frame apart",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10, 20, 30), audioTrack('x', 5)}},
+				{Tracks: []testTrack{videoTrack('b', 40, 50), audioTrack('y', 6, 6)}},
+			},
+			err: ErrConcatIncompatible,
+		},
+		{
+			name: "different sample descriptions",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30)}},
+				{Title: "second", Tracks: []testTrack{func() testTrack {
+					track := videoTrack('b', 40, 50)
+					track.Entry = testBox("hvc1", make([]byte, 8))
+					return track
+				}()}},
+			},
+			sync:     []uint32{1, 4},
+			entries:  2,
+			duration: 166 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "input without a sync table",
+			inputs: []testInput{
+				{Title: "first", Tracks: []testTrack{videoTrack('a', 10, 20, 30)}},
+				{Title: "second", Tracks: []testTrack{func() testTrack {
+					track := videoTrack('b', 40, 50)
+					track.Sync = nil
+					return track
+				}()}},
+			},
+			sync:     []uint32{1, 4, 5},
+			entries:  1,
+			duration: 166 * time.Millisecond,
+			chapters: []testChapter{{0, "first"}, {100 * time.Millisecond, "second"}},
+		},
+		{
+			name: "untitled inputs use the filename",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10)}},
+				{Tracks: []testTrack{videoTrack('b', 20)}},
+			},
+			sync:     []uint32{1, 2},
+			entries:  1,
+			duration: 66 * time.Millisecond,
+			chapters: []testChapter{{0, "0.mp4"}, {33333300, "1.mp4"}},
+		},
+		{
+			name: "different timescales",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10)}},
+				{Tracks: []testTrack{func() testTrack {
+					track := videoTrack('b', 20)
+					track.Timescale = 30000
+					return track
+				}()}},
+			},
+			err: ErrConcatIncompatible,
+		},
+		{
+			name: "different tracks",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10), audioTrack('x', 5)}},
+				{Tracks: []testTrack{videoTrack('b', 20)}},
+			},
+			err: ErrConcatIncompatible,
+		},
+		{
+			name: "fragmented input",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10)}},
+				{Raw: bytes.Join([][]byte{testFtyp, testBox("moov", testBox("mvhd", testMvhd(1000, 0))), testBox("moof"), testBox("mdat")}, nil)},
+			},
+			err: ErrConcatIncompatible,
+		},
+		{
+			name: "not an mp4",
+			inputs: []testInput{
+				{Tracks: []testTrack{videoTrack('a', 10)}},
+				{Raw: bytes.Join([][]byte{testFtyp, testBox("mdat")}, nil)},
+			},
+			err: ErrNotMP4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			var inputs []ConcatInput
+			for i, input := range test.inputs {
+				filename := filepath.Join(directory, fmt.Sprintf("%d.mp4", i))
+				if input.Raw != nil {
+					writeTestFile(t, filename, input.Raw)
+				} else {
+					writeTestMP4(t, filename, input.MdatFirst, input.Tracks...)
+				}
+				inputs = append(inputs, ConcatInput{Filename: filename, Title: input.Title})
+			}
+
+			output := filepath.Join(directory, "joined", "output.mp4")
+			err := ConcatMP4(output, inputs)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, expected %v", err, test.err)
+			}
+			if err != nil {
+				if FileExists(output) || FileExists(output+".tmp") {
+					t.Error("failed join left a file behind")
+				}
+				return
+			}
+
+			info, err := InspectMP4(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Duration != test.duration {
+				t.Errorf("got duration %s, expected %s", info.Duration, test.duration)
+			}
+
+			joined, err := openConcatSource(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer joined.fh.Close()
+			data, err := ioutil.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(joined.tracks) != len(test.inputs[0].Tracks) {
+				t.Fatalf("got %d tracks, expected %d", len(joined.tracks), len(test.inputs[0].Tracks))
+			}
+			for i, track := range joined.tracks {
+				var sizes []uint32
+				var samples uint32
+				var stsc [][3]uint32
+				for k, input := range test.inputs {
+					expected := input.Tracks[i]
+					sizes = append(sizes, expected.Sizes...)
+					samples += uint32(len(expected.Sizes))
+					description := uint32(1)
+					if i == 0 && test.entries > 1 {
+						description = uint32(k + 1)
+					}
+					stsc = append(stsc, [3]uint32{uint32(k + 1), uint32(len(expected.Sizes)), description})
+
+					// every input is one chunk which must still point at its samples
+					payload := expected.payload()
+					offset := track.chunks[k]
+					if offset+uint64(len(payload)) > uint64(len(data)) || !bytes.Equal(data[offset:offset+uint64(len(payload))], payload) {
+						t.Errorf("track %d chunk %d at %d does not hold the samples of input %d", i, k+1, offset, k)
+					}
+				}
+				if !reflect.DeepEqual(track.sizes, sizes) {
+					t.Errorf("track %d has sample sizes %v, expected %v", i, track.sizes, sizes)
+				}
+				if !reflect.DeepEqual(track.stsc, stsc) {
+					t.Errorf("track %d has stsc %v, expected %v", i, track.stsc, stsc)
+				}
+				delta := test.inputs[0].Tracks[i].Delta
+				if !reflect.DeepEqual(track.stts, [][2]uint32{{samples, delta}}) {
+					t.Errorf("track %d has stts %v, expected %d samples of %d", i, track.stts, samples, delta)
+				}
+				if track.duration != uint64(samples*delta) {
+					t.Errorf("track %d lasts %d, expected %d", i, track.duration, samples*delta)
+				}
+				if track.trak.Child("edts") != nil {
+					t.Errorf("track %d still has an edit list", i)
+				}
+			}
+
+			if !reflect.DeepEqual(joined.tracks[0].sync, test.sync) {
+				t.Errorf("got sync samples %v, expected %v", joined.tracks[0].sync, test.sync)
+			}
+			if len(joined.tracks[0].entries) != test.entries {
+				t.Errorf("got %d sample descriptions, expected %d", len(joined.tracks[0].entries), test.entries)
+			}
+			if chapters := readChapters(t, joined.moov); !reflect.DeepEqual(chapters, test.chapters) {
+				t.Errorf("got chapters %v, expected %v", chapters, test.chapters)
+			}
+		})
+	}
+}
+
+func TestConcatMP4NoInputs(t *testing.T) {
+	err := ConcatMP4(filepath.Join(t.TempDir(), "output.mp4"), nil)
+	if err == nil {
+		t.Error("joined nothing")
+	}
+}
+
+func TestShiftChunkOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		boxType  string
+		offsets  []uint64
+		delta    int64
+		expected []uint64
+		result   string
+	}{
+		{
+			name:     "stco",
+			boxType:  "stco",
+			offsets:  []uint64{100, 200},
+			delta:    50,
+			expected: []uint64{150, 250},
+			result:   "stco",
+		},
+		{
+			name:     "stco moved back",
+			boxType:  "stco",
+			offsets:  []uint64{100, 200},
+			delta:    -50,
+			expected: []uint64{50, 150},
+			result:   "stco",
+		},
+		{
+			name:     "stco past 4GB becomes co64",
+			boxType:  "stco",
+			offsets:  []uint64{100, math.MaxUint32 - 10},
+			delta:    20,
+			expected: []uint64{120, math.MaxUint32 + 10},
+			result:   "co64",
+		},
+		{
+			name:     "co64 stays co64",
+			boxType:  "co64",
+			offsets:  []uint64{100, 200},
+			delta:    50,
+			expected: []uint64{150, 250},
+			result:   "co64",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks := &atom{Type: test.boxType}
+			setChunkOffsets(chunks, test.offsets)
+			moov := &atom{Type: "moov", Children: []*atom{{Type: "trak", Children: []*atom{chunks}}}}
+
+			err := moov.shiftChunkOffsets(test.delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunks.Type != test.result {
+				t.Errorf("got %s, expected %s", chunks.Type, test.result)
+			}
+			offsets, err := chunkOffsets(chunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(offsets, test.expected) {
+				t.Errorf("got offsets %v, expected %v", offsets, test.expected)
+			}
+		})
+	}
+}
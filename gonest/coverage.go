@@ -0,0 +1,106 @@
+package gonest
+
+import (
+	"sort"
+	"time"
+)
+
+// Coverage compares a requested range of camera history with the time the
+// downloaded clips actually hold
+type Coverage struct {
+	Requested TimeRange
+	Covered   time.Duration
+	Gaps      []TimeRange
+	Overlaps  []TimeRange
+}
+
+func (c Coverage) Complete() bool {
+	return len(c.Gaps) == 0
+}
+
+// CheckCoverage finds the parts of requested no clip covers and the parts
+// covered more than once. Gaps and overlaps up to tolerance are ignored, clip
+// lengths are rounded by the server.
+func CheckCoverage(requested TimeRange, clips []TimeRange, tolerance time.Duration) Coverage {
+	coverage := Coverage{Requested: requested}
+
+	var inside []TimeRange
+	for _, clip := range clips {
+		if !clip.End.After(requested.Start) || !clip.Start.Before(requested.End) {
+			continue
+		}
+		if clip.Start.Before(requested.Start) {
+			clip.Start = requested.Start
+		}
+		if clip.End.After(requested.End) {
+			clip.End = requested.End
+		}
+		inside = append(inside, clip)
+	}
+	sort.Slice(inside, func(i, j int) bool {
+		return inside[i].Start.Before(inside[j].Start)
+	})
+
+	cursor := requested.Start
+	for _, clip := range inside {
+		if clip.Start.Sub(cursor) > tolerance {
+			coverage.Gaps = append(coverage.Gaps, TimeRange{Start: cursor, End: clip.Start})
+		}
+		if cursor.Sub(clip.Start) > tolerance {
+			end := cursor
+			if clip.End.Before(end) {
+				end = clip.End
+			}
+			if end.Sub(clip.Start) > tolerance {
+				coverage.Overlaps = append(coverage.Overlaps, TimeRange{Start: clip.Start, End: end})
+			}
+		}
+
+		start := clip.Start
+		if start.Before(cursor) {
+			start = cursor
+		}
+		if clip.End.After(start) {
+			coverage.Covered += clip.End.Sub(start)
+			cursor = clip.End
+		}
+	}
+	if requested.End.Sub(cursor) > tolerance {
+		coverage.Gaps = append(coverage.Gaps, TimeRange{Start: cursor, End: requested.End})
+	}
+	return coverage
+}
+
+// Ranges returns the time held by complete downloads of camera uuid which
+// overlap r
+func (m *Manifest) Ranges(uuid string, r TimeRange) []TimeRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ranges []TimeRange
+	for _, entry := range m.Entries {
+		if entry.CameraUUID != uuid || entry.Status != ManifestComplete {
+			continue
+		}
+		if !entry.End.After(r.Start) || !entry.Start.Before(r.End) {
+			continue
+		}
+		ranges = append(ranges, TimeRange{Start: entry.Start, End: entry.End})
+	}
+	return ranges
+}
+
+// Cameras returns the camera uuids with entries in the manifest
+func (m *Manifest) Cameras() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	var cameras []string
+	for _, entry := range m.Entries {
+		if entry.CameraUUID != "" && !seen[entry.CameraUUID] {
+			seen[entry.CameraUUID] = true
+			cameras = append(cameras, entry.CameraUUID)
+		}
+	}
+	sort.Strings(cameras)
+	return cameras
+}
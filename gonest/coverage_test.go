@@ -0,0 +1,111 @@
+package gonest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckCoverage(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	span := func(start, end time.Duration) TimeRange {
+		return TimeRange{Start: base.Add(start), End: base.Add(end)}
+	}
+	requested := span(0, time.Hour)
+
+	tests := []struct {
+		name      string
+		clips     []TimeRange
+		tolerance time.Duration
+		covered   time.Duration
+		gaps      []TimeRange
+		overlaps  []TimeRange
+	}{
+		{
+			name:    "nothing",
+			gaps:    []TimeRange{requested},
+			covered: 0,
+		},
+		{
+			name:    "back to back",
+			clips:   []TimeRange{span(30*time.Minute, time.Hour), span(0, 30*time.Minute)},
+			covered: time.Hour,
+		},
+		{
+			name:    "clips beyond the range are cut off",
+			clips:   []TimeRange{span(-time.Hour, 20*time.Minute), span(20*time.Minute, 2*time.Hour), span(3*time.Hour, 4*time.Hour)},
+			covered: time.Hour,
+		},
+		{
+			name:    "gaps at the start, middle and end",
+			clips:   []TimeRange{span(10*time.Minute, 20*time.Minute), span(30*time.Minute, 50*time.Minute)},
+			covered: 30 * time.Minute,
+			gaps:    []TimeRange{span(0, 10*time.Minute), span(20*time.Minute, 30*time.Minute), span(50*time.Minute, time.Hour)},
+		},
+		{
+			name:      "gaps within tolerance are ignored",
+			clips:     []TimeRange{span(time.Second, 30*time.Minute), span(30*time.Minute+2*time.Second, time.Hour-time.Second)},
+			tolerance: 2 * time.Second,
+			covered:   time.Hour - 4*time.Second,
+		},
+		{
+			name:      "gaps past tolerance are reported",
+			clips:     []TimeRange{span(0, 30*time.Minute), span(30*time.Minute+3*time.Second, time.Hour)},
+			tolerance: 2 * time.Second,
+			covered:   time.Hour - 3*time.Second,
+			gaps:      []TimeRange{span(30*time.Minute, 30*time.Minute+3*time.Second)},
+		},
+		{
+			name:     "overlaps",
+			clips:    []TimeRange{span(0, 40*time.Minute), span(30*time.Minute, time.Hour)},
+			covered:  time.Hour,
+			overlaps: []TimeRange{span(30*time.Minute, 40*time.Minute)},
+		},
+		{
+			name:     "clip inside another",
+			clips:    []TimeRange{span(0, time.Hour), span(10*time.Minute, 20*time.Minute)},
+			covered:  time.Hour,
+			overlaps: []TimeRange{span(10*time.Minute, 20*time.Minute)},
+		},
+		{
+			name:      "overlaps within tolerance are ignored",
+			clips:     []TimeRange{span(0, 30*time.Minute+time.Second), span(30*time.Minute, time.Hour)},
+			tolerance: 2 * time.Second,
+			covered:   time.Hour,
+		},
+		{
+			name:     "duplicate clips",
+			clips:    []TimeRange{span(0, time.Hour), span(0, time.Hour)},
+			covered:  time.Hour,
+			overlaps: []TimeRange{requested},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coverage := CheckCoverage(requested, test.clips, test.tolerance)
+			if coverage.Covered != test.covered {
+				t.Errorf("got %s covered, expected %s", coverage.Covered, test.covered)
+			}
+			if !equalRanges(coverage.Gaps, test.gaps) {
+				t.Errorf("got gaps %v, expected %v", coverage.Gaps, test.gaps)
+			}
+			if !equalRanges(coverage.Overlaps, test.overlaps) {
+				t.Errorf("got overlaps %v, expected %v", coverage.Overlaps, test.overlaps)
+			}
+			if coverage.Complete() != (len(test.gaps) == 0) {
+				t.Errorf("got complete %t with gaps %v", coverage.Complete(), test.gaps)
+			}
+		})
+	}
+}
+
+func equalRanges(a, b []TimeRange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Start.Equal(b[i].Start) || !a[i].End.Equal(b[i].End) {
+			return false
+		}
+	}
+	return true
+}
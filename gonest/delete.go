@@ -0,0 +1,140 @@
+package gonest
+
+import (
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ClipFilter selects clips, every non-zero field must match
+type ClipFilter struct {
+	OlderThan       time.Duration
+	CameraUUID      string
+	Title           *regexp.Regexp
+	CreatedByGonest bool
+}
+
+type DeleteResult struct {
+	Clip  *Clip
+	Error error
+}
+
+func (f ClipFilter) Match(c *Clip) bool {
+	if f.OlderThan > 0 && time.Since(c.GeneratedTime()) < f.OlderThan {
+		return false
+	}
+	if f.CameraUUID != "" && f.CameraUUID != c.CameraUUID {
+		return false
+	}
+	if f.Title != nil && !f.Title.MatchString(c.Title) {
+		return false
+	}
+	if f.CreatedByGonest && !c.nest.createdClip(c.ID) {
+		return false
+	}
+	return true
+}
+
+// FilterClips lists the clips matching filter, the ids of clips which are gone
+// from the clip list are pruned from CreatedClips on the way
+func (n *Nest) FilterClips(filter ClipFilter) ([]*Clip, error) {
+	created := n.createdClips()
+	clips, err := n.ListClips()
+	if err != nil {
+		return nil, err
+	}
+	n.pruneCreatedClips(created, clips)
+
+	var matched []*Clip
+	for _, clip := range clips {
+		if filter.Match(clip) {
+			matched = append(matched, clip)
+		}
+	}
+	return matched, nil
+}
+
+// DeleteClips deletes every clip matching filter, see DeleteClipList
+func (n *Nest) DeleteClips(filter ClipFilter, concurrency int) ([]DeleteResult, error) {
+	clips, err := n.FilterClips(filter)
+	if err != nil {
+		return nil, err
+	}
+	return n.DeleteClipList(clips, concurrency), nil
+}
+
+// DeleteClipList deletes clips with at most concurrency deletes in flight,
+// results are returned in the same order as clips
+func (n *Nest) DeleteClipList(clips []*Clip, concurrency int) []DeleteResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]DeleteResult, len(clips))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = DeleteResult{
+					Clip:  clips[i],
+					Error: clips[i].Delete(),
+				}
+			}
+		}()
+	}
+
+	for i := range clips {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+func (n *Nest) createdClip(id int) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, created := range n.CreatedClips {
+		if created == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Nest) forgetCreatedClip(id int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, created := range n.CreatedClips {
+		if created == id {
+			n.CreatedClips = append(n.CreatedClips[:i], n.CreatedClips[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *Nest) createdClips() []int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]int(nil), n.CreatedClips...)
+}
+
+// pruneCreatedClips forgets the ids in created which are missing from clips,
+// those clips were deleted elsewhere or expired. Only ids known before clips
+// was fetched are pruned, a clip created since is not in it yet.
+func (n *Nest) pruneCreatedClips(created []int, clips []*Clip) {
+	present := make(map[int]bool)
+	for _, clip := range clips {
+		present[clip.ID] = true
+	}
+	for _, id := range created {
+		if !present[id] {
+			n.forgetCreatedClip(id)
+		}
+	}
+}
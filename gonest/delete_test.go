@@ -0,0 +1,86 @@
+package gonest
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestClipFilterMatch(t *testing.T) {
+	nest := &Nest{CreatedClips: []int{1}}
+	old := float64(time.Now().Add(-48 * time.Hour).Unix())
+	recent := float64(time.Now().Add(-time.Hour).Unix())
+	clip := func(id int, camera string, title string, generated float64) *Clip {
+		return &Clip{nest: nest, ID: id, CameraUUID: camera, Title: title, GeneratedtedTimeFloat: generated}
+	}
+
+	tests := []struct {
+		name     string
+		filter   ClipFilter
+		clip     *Clip
+		expected bool
+	}{
+		{name: "empty filter", clip: clip(2, "a", "", recent), expected: true},
+		{name: "older", filter: ClipFilter{OlderThan: 24 * time.Hour}, clip: clip(2, "a", "", old), expected: true},
+		{name: "too recent", filter: ClipFilter{OlderThan: 24 * time.Hour}, clip: clip(2, "a", "", recent)},
+		{name: "camera", filter: ClipFilter{CameraUUID: "a"}, clip: clip(2, "a", "", recent), expected: true},
+		{name: "other camera", filter: ClipFilter{CameraUUID: "b"}, clip: clip(2, "a", "", recent)},
+		{name: "title", filter: ClipFilter{Title: regexp.MustCompile("^gonest")}, clip: clip(2, "a", "gonest 1", recent), expected: true},
+		{name: "other title", filter: ClipFilter{Title: regexp.MustCompile("^gonest")}, clip: clip(2, "a", "party", recent)},
+		{name: "created by gonest", filter: ClipFilter{CreatedByGonest: true}, clip: clip(1, "a", "", recent), expected: true},
+		{name: "created elsewhere", filter: ClipFilter{CreatedByGonest: true}, clip: clip(2, "a", "", recent)},
+		{
+			name:     "every field",
+			filter:   ClipFilter{OlderThan: 24 * time.Hour, CameraUUID: "a", CreatedByGonest: true},
+			clip:     clip(1, "a", "", old),
+			expected: true,
+		},
+		{
+			name:   "one field off",
+			filter: ClipFilter{OlderThan: 24 * time.Hour, CameraUUID: "b", CreatedByGonest: true},
+			clip:   clip(1, "a", "", old),
+		},
+	}
+
+	for _, test := range tests {
+		if matched := test.filter.Match(test.clip); matched != test.expected {
+			t.Errorf("%s: got %t, expected %t", test.name, matched, test.expected)
+		}
+	}
+}
+
+func TestFilterClips(t *testing.T) {
+	old := float64(time.Now().Add(-48 * time.Hour).Unix())
+	api, nest := newFakeAPI(t,
+		&Clip{ID: 1, CameraUUID: "a", GeneratedtedTimeFloat: old},
+		&Clip{ID: 2, CameraUUID: "a", GeneratedtedTimeFloat: old},
+		&Clip{ID: 3, CameraUUID: "b", GeneratedtedTimeFloat: old},
+	)
+	// 9 was deleted elsewhere, 10 is created while the clip list is fetched
+	nest.CreatedClips = []int{1, 3, 9}
+	api.poll = func(polls int, clips []*Clip) {
+		nest.mu.Lock()
+		nest.CreatedClips = append(nest.CreatedClips, 10)
+		nest.mu.Unlock()
+	}
+
+	clips, err := nest.FilterClips(ClipFilter{CreatedByGonest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := clipIDs(clips); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("got %v, expected the clips created by gonest [1 3]", ids)
+	}
+	if !reflect.DeepEqual(nest.CreatedClips, []int{1, 3, 10}) {
+		t.Errorf("got created clips %v, expected 9 to be pruned and nothing else", nest.CreatedClips)
+	}
+
+	clips, err = nest.FilterClips(ClipFilter{CameraUUID: "a", OlderThan: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := clipIDs(clips); !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("got %v, expected the clips of camera a [1 2]", ids)
+	}
+}
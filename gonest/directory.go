@@ -0,0 +1,56 @@
+package gonest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DirectoryMode is the permission given to directories created for clips,
+// manifests, checkpoints and archive state
+var DirectoryMode os.FileMode = 0755
+
+// ParseDirectoryMode parses an octal permission such as 0750
+func ParseDirectoryMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid directory mode %q, use octal such as 0755", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// mkdirAll creates dir and any missing parents with DirectoryMode. The mode
+// is set explicitly on every directory it creates so the umask doesn't
+// narrow it, existing directories are left alone.
+func mkdirAll(dir string) error {
+	var missing []string
+	for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+		_, err := os.Stat(current)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, DirectoryMode)
+	if err != nil {
+		return err
+	}
+	for _, created := range missing {
+		err = os.Chmod(created, DirectoryMode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package gonest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDirectoryMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected os.FileMode
+		invalid  bool
+	}{
+		{mode: "0755", expected: 0755},
+		{mode: "750", expected: 0750},
+		{mode: "0777", expected: 0777},
+		{mode: "0", expected: 0},
+		{mode: "", invalid: true},
+		{mode: "0o755", invalid: true},
+		{mode: "0758", invalid: true},
+		{mode: "1777", invalid: true},
+		{mode: "-755", invalid: true},
+		{mode: "rwxr-xr-x", invalid: true},
+	}
+	for _, test := range tests {
+		mode, err := ParseDirectoryMode(test.mode)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: parsed as %o", test.mode, mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.mode, err)
+			continue
+		}
+		if mode != test.expected {
+			t.Errorf("%q: got %o, expected %o", test.mode, mode, test.expected)
+		}
+	}
+}
+
+func TestMkdirAllExisting(t *testing.T) {
+	defer func(mode os.FileMode) {
+		DirectoryMode = mode
+	}(DirectoryMode)
+	DirectoryMode = 0700
+
+	// directories which are already there keep their mode
+	existing := filepath.Join(t.TempDir(), "existing")
+	err := os.Mkdir(existing, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(existing, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mkdirAll(filepath.Join(existing, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("existing directory changed to %o", info.Mode().Perm())
+	}
+
+	err = mkdirAll(existing)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package gonest
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMkdirAllUmask(t *testing.T) {
+	defer func(mode os.FileMode) {
+		DirectoryMode = mode
+	}(DirectoryMode)
+	defer syscall.Umask(syscall.Umask(027))
+
+	for _, mode := range []os.FileMode{0775, 0777, 0750} {
+		DirectoryMode = mode
+		base := t.TempDir()
+		dir := filepath.Join(base, "a", "b", "c")
+		err := mkdirAll(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, created := range []string{filepath.Join(base, "a"), filepath.Join(base, "a", "b"), dir} {
+			info, err := os.Stat(created)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != mode {
+				t.Errorf("%s got mode %o, expected %o despite the umask", created, info.Mode().Perm(), mode)
+			}
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package gonest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// resumeState is kept next to a partial download so a later Save can continue
+// it with a Range request, as long as the remote object has not changed
+type resumeState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Size         int64  `json:"size"`
+}
+
+type SaveOptions struct {
+	// Progress, if set, is called as the download moves through its states
+	// and at most every ProgressInterval while bytes are being received
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+	// Sidecar writes a .json file with the clip record next to the video
+	Sidecar bool
+	// EmbedMetadata stores the clip start time and title in the mp4 itself
+	EmbedMetadata bool
+	// RateLimit limits this download in bytes per second, on top of any
+	// limit on the Nest
+	RateLimit int64
+	// StagingDir, if set, holds partial downloads instead of the destination
+	// directory. It has to be on the same filesystem as the destination.
+	StagingDir string
+	// Log, if set, receives the log output of the download instead of the
+	// standard logger
+	Log log.FieldLogger
+}
+
+// SidecarMissing reports if a sidecar was asked for but filename doesn't
+// have one, the sidecar is written last so such a video is incomplete
+func (o SaveOptions) SidecarMissing(filename string) bool {
+	return o.Sidecar && !FileExists(SidecarFilename(filename))
+}
+
+// limit wraps r in a limiter of its own when RateLimit is set
+func (o SaveOptions) limit(ctx context.Context, r io.Reader) io.Reader {
+	if o.RateLimit <= 0 {
+		return r
+	}
+	return NewBandwidthLimiter(o.RateLimit, nil).Reader(ctx, r)
+}
+
+func (o SaveOptions) logger() log.FieldLogger {
+	if o.Log == nil {
+		return log.StandardLogger()
+	}
+	return o.Log
+}
+
+var errRestartDownload = errors.New("partial download can not be resumed")
+
+// fetch retries url until the clip servers return it, which can take a while
+// for freshly requested clips which are still being processed. Range
+// responses (206 and 416) are returned to the caller as they are.
+func (c Clip) fetch(ctx context.Context, url string, header http.Header, progress *progressWriter, logger log.FieldLogger) (*http.Response, error) {
+	attempts := 0
+	for {
+		request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error": err,
+				"id":    c.ID,
+				"url":   url,
+			}).Error("failed create new HTTP request for clip download")
+			return nil, err
+		}
+
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		request.Header.Add("Cookie", fmt.Sprintf("cztoken=%s; user_token=%s", c.nest.CZToken, c.nest.UserToken))
+
+		response, err := c.nest.httpClient.Do(request)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error": err,
+				"id":    c.ID,
+				"url":   url,
+			}).Error("failed to fetch clip")
+			return nil, err
+		}
+
+		if response.StatusCode == 403 {
+			response.Body.Close()
+			return nil, errors.New("unable to save clip: 403")
+		}
+
+		if response.StatusCode == 404 {
+			response.Body.Close()
+			logger.WithFields(log.Fields{
+				"status":   response.Status,
+				"id":       c.ID,
+				"url":      url,
+				"attempts": attempts,
+			}).Info("waiting for file")
+			attempts += 1
+			progress.waiting(attempts)
+			if attempts > 300 {
+				return nil, errors.New("unable to save clip: clip not processed after 300 seconds")
+			}
+			err = sleepContext(ctx, time.Second)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if response.StatusCode != 200 && response.StatusCode != 206 && response.StatusCode != 416 {
+			response.Body.Close()
+			logger.WithFields(log.Fields{
+				"status":   response.Status,
+				"id":       c.ID,
+				"url":      url,
+				"attempts": attempts,
+			}).Info("hopefully temporary error fetching clip")
+			attempts += 1
+			if attempts > 300 {
+				return nil, errors.New("unable to save clip: too many errors")
+			}
+			err = sleepContext(ctx, time.Second)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if c.nest.Bandwidth != nil {
+			response.Body = limitedReadCloser{
+				Reader: c.nest.Bandwidth.Reader(ctx, response.Body),
+				Closer: response.Body,
+			}
+		}
+		return response, nil
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// saveURL downloads url to filename, verify and then prepare are called on the
+// complete download before it is moved into place. A partial download which
+// turns out to be stale is restarted once from the beginning.
+func (c Clip) saveURL(url string, filename string, verify func(string) error, prepare func(string) error, opts SaveOptions) error {
+	logger := opts.logger()
+	progress := newProgressWriter(opts, c.ID, filename)
+	err := c.download(context.Background(), url, filename, verify, prepare, progress, opts, true)
+	if err == errRestartDownload {
+		logger.WithFields(log.Fields{
+			"id":       c.ID,
+			"filename": filename,
+			"url":      url,
+		}).Info("partial download is stale, starting over")
+		err = c.download(context.Background(), url, filename, verify, prepare, progress, opts, false)
+	}
+	if err != nil {
+		progress.state(ProgressFailed)
+	} else {
+		progress.state(ProgressDone)
+	}
+	return err
+}
+
+// partialFilename is where filename is downloaded to before being renamed
+// into place
+func (c Clip) partialFilename(filename string, opts SaveOptions) string {
+	if opts.StagingDir == "" {
+		return fmt.Sprintf("%s.tmp", filename)
+	}
+	return filepath.Join(opts.StagingDir, fmt.Sprintf("%d-%s.tmp", c.ID, filepath.Base(filename)))
+}
+
+func (c Clip) download(ctx context.Context, url string, filename string, verify func(string) error, prepare func(string) error, progress *progressWriter, opts SaveOptions, resume bool) error {
+	logger := opts.logger()
+	tmpFilename := c.partialFilename(filename, opts)
+	resumeFilename := fmt.Sprintf("%s.resume", tmpFilename)
+
+	for _, dir := range []string{filepath.Dir(filename), filepath.Dir(tmpFilename)} {
+		err := mkdirAll(dir)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("failed to create directory for clip download")
+			return err
+		}
+	}
+
+	var state resumeState
+	var offset int64
+	if resume {
+		offset, state = resumeOffset(url, tmpFilename, resumeFilename)
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if state.ETag != "" {
+			header.Set("If-Range", state.ETag)
+		} else {
+			header.Set("If-Range", state.LastModified)
+		}
+	}
+
+	response, err := c.fetch(ctx, url, header, progress, logger)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var fh *os.File
+	switch response.StatusCode {
+	case 206:
+		if offset == 0 {
+			// nothing was asked for by range, so there is nothing to restart
+			return fmt.Errorf("unable to save clip: unexpected %s", response.Status)
+		}
+		if !resumable(response, offset, state) {
+			return errRestartDownload
+		}
+		logger.WithFields(log.Fields{
+			"id":       c.ID,
+			"filename": filename,
+			"offset":   offset,
+			"size":     state.Size,
+		}).Info("resuming partial download")
+		fh, err = os.OpenFile(tmpFilename, os.O_WRONLY|os.O_APPEND, 0644)
+	case 416:
+		if offset == 0 {
+			return fmt.Errorf("unable to save clip: %s", response.Status)
+		}
+		if offset != state.Size {
+			return errRestartDownload
+		}
+		// everything was already downloaded, only the rename is missing
+		progress.state(ProgressVerifying)
+		err = c.verifyDownload(tmpFilename, resumeFilename, state.Size, verify, logger)
+		if err != nil {
+			return err
+		}
+		err = prepareDownload(tmpFilename, resumeFilename, prepare)
+		if err != nil {
+			return err
+		}
+		return finishDownload(tmpFilename, resumeFilename, filename)
+	default:
+		fh, err = os.Create(tmpFilename)
+		if err == nil {
+			state = resumeState{
+				URL:          url,
+				ETag:         response.Header.Get("ETag"),
+				LastModified: response.Header.Get("Last-Modified"),
+				Size:         response.ContentLength,
+			}
+			if state.Size > 0 && (state.ETag != "" || state.LastModified != "") {
+				golib.SaveFile(resumeFilename, state)
+			} else {
+				os.Remove(resumeFilename)
+			}
+		}
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"error": err,
+			"id":    c.ID,
+			"url":   url,
+		}).Error("failed open file for clip download")
+		return err
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			fh.Close()
+		}
+	}()
+
+	if response.StatusCode == 206 {
+		progress.start(offset, state.Size)
+	} else {
+		progress.start(0, response.ContentLength)
+	}
+
+	logger.WithFields(log.Fields{
+		"id":       c.ID,
+		"filename": filename,
+		"url":      url,
+	}).Info("saving file")
+	_, err = io.Copy(io.MultiWriter(fh, progress), opts.limit(ctx, response.Body))
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":          c.ID,
+			"filename":    filename,
+			"tmpFilename": tmpFilename,
+			"error":       err,
+			"url":         url,
+		}).Info("saving file failed")
+		return err
+	}
+
+	// the data has to be on disk before the rename makes it look complete
+	err = fh.Sync()
+	if err == nil {
+		closed = true
+		err = fh.Close()
+	}
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":          c.ID,
+			"tmpFilename": tmpFilename,
+			"error":       err,
+		}).Error("failed to flush file to disk")
+		return err
+	}
+
+	progress.state(ProgressVerifying)
+	err = c.verifyDownload(tmpFilename, resumeFilename, state.Size, verify, logger)
+	if err != nil {
+		return err
+	}
+	err = prepareDownload(tmpFilename, resumeFilename, prepare)
+	if err != nil {
+		return err
+	}
+
+	err = finishDownload(tmpFilename, resumeFilename, filename)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":          c.ID,
+			"filename":    filename,
+			"tmpFilename": tmpFilename,
+			"error":       err,
+			"url":         url,
+		}).Info("renaming file failed")
+		return err
+	}
+
+	return nil
+}
+
+// verifyDownload checks the partial file holds every byte the server
+// announced, then runs verify. Files which fail verify are removed because
+// resuming them would not help.
+func (c Clip) verifyDownload(tmpFilename string, resumeFilename string, size int64, verify func(string) error, logger log.FieldLogger) error {
+	if size > 0 {
+		info, err := os.Stat(tmpFilename)
+		if err != nil {
+			return err
+		}
+		if info.Size() != size {
+			logger.WithFields(log.Fields{
+				"id":          c.ID,
+				"tmpFilename": tmpFilename,
+				"size":        info.Size(),
+				"expected":    size,
+			}).Error("download size does not match content length")
+			return fmt.Errorf("%w: got %d of %d bytes", ErrTruncated, info.Size(), size)
+		}
+	}
+
+	if verify == nil {
+		return nil
+	}
+	err := verify(tmpFilename)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":          c.ID,
+			"tmpFilename": tmpFilename,
+			"error":       err,
+		}).Error("download failed verification")
+		os.Remove(tmpFilename)
+		os.Remove(resumeFilename)
+		return err
+	}
+	return nil
+}
+
+// prepareDownload runs prepare on a verified partial file. Once prepare has
+// changed the file it no longer matches the server, so it stops being
+// resumable and is dropped if prepare fails.
+func prepareDownload(tmpFilename string, resumeFilename string, prepare func(string) error) error {
+	if prepare == nil {
+		return nil
+	}
+	os.Remove(resumeFilename)
+	err := prepare(tmpFilename)
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+	return nil
+}
+
+func finishDownload(tmpFilename string, resumeFilename string, filename string) error {
+	err := os.Rename(tmpFilename, filename)
+	if err != nil {
+		var linkErr *os.LinkError
+		if errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV {
+			return fmt.Errorf("staging directory must be on the same filesystem as %s: %w", filename, err)
+		}
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	os.Remove(resumeFilename)
+	return nil
+}
+
+// syncDir makes a rename into dir durable, failures are only logged since not
+// every platform can sync directories
+func syncDir(dir string) {
+	fh, err := os.Open(dir)
+	if err == nil {
+		err = fh.Sync()
+		fh.Close()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": dir,
+			"error":     err,
+		}).Debug("failed to sync directory")
+	}
+}
+
+type CleanupResult struct {
+	Resumable []string
+	Removed   []string
+}
+
+// CleanupPartialDownloads looks for partial downloads left behind in dir by
+// an earlier crash. Partials which Save can resume are kept, anything else
+// which has not been touched for olderThan is removed.
+func CleanupPartialDownloads(dir string, olderThan time.Duration) (*CleanupResult, error) {
+	result := &CleanupResult{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		// only ever touch files which look like clip or thumbnail downloads
+		var partial string
+		switch {
+		case strings.HasSuffix(path, ".mp4.tmp"), strings.HasSuffix(path, ".jpg.tmp"):
+			partial = path
+		case strings.HasSuffix(path, ".mp4.tmp.resume"), strings.HasSuffix(path, ".jpg.tmp.resume"):
+			partial = strings.TrimSuffix(path, ".resume")
+		case strings.HasSuffix(path, ".mp4.rewrite"), strings.HasSuffix(path, ".mp4.tmp.rewrite"):
+		default:
+			return nil
+		}
+
+		if partial == path {
+			var state resumeState
+			err := golib.LoadFile(partial+".resume", &state)
+			if err == nil && state.Size > 0 && info.Size() <= state.Size {
+				result.Resumable = append(result.Resumable, path)
+				return nil
+			}
+		} else if partial != "" && FileExists(partial) {
+			// handled together with its partial file
+			return nil
+		}
+
+		if time.Since(info.ModTime()) < olderThan {
+			return nil
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		if partial == path {
+			os.Remove(partial + ".resume")
+		}
+		result.Removed = append(result.Removed, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resumeOffset returns how much of url is already in tmpFilename, or 0 when
+// the partial file can not be trusted
+func resumeOffset(url string, tmpFilename string, resumeFilename string) (int64, resumeState) {
+	var state resumeState
+	err := golib.LoadFile(resumeFilename, &state)
+	if err != nil || state.URL != url || state.Size <= 0 {
+		return 0, state
+	}
+
+	info, err := os.Stat(tmpFilename)
+	if err != nil || info.Size() > state.Size {
+		return 0, state
+	}
+
+	return info.Size(), state
+}
+
+// resumable checks that a 206 response continues exactly where the partial
+// file ends and belongs to the same object that was originally downloaded
+func resumable(response *http.Response, offset int64, state resumeState) bool {
+	etag := response.Header.Get("ETag")
+	if state.ETag != "" && etag != "" && etag != state.ETag {
+		return false
+	}
+
+	var start, end, size int64
+	_, err := fmt.Sscanf(response.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size)
+	if err != nil {
+		return false
+	}
+
+	return start == offset && size == state.Size && end == size-1
+}
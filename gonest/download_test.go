@@ -0,0 +1,325 @@
+package gonest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+)
+
+func TestSaveURLResume(t *testing.T) {
+	const video = "0123456789"
+	partial := func(w http.ResponseWriter, etag string, start int, body string) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(video)-1, len(video)))
+		w.WriteHeader(206)
+		w.Write([]byte(body))
+	}
+	full := func(w http.ResponseWriter, etag string, body string) {
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}
+
+	tests := []struct {
+		name     string
+		partial  string
+		handler  func(w http.ResponseWriter, r *http.Request, request int)
+		expected string
+		requests int
+		err      error
+	}{
+		{
+			name:    "206 with the same etag appends",
+			partial: video[:5],
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				if r.Header.Get("Range") != "bytes=5-" || r.Header.Get("If-Range") != `"a"` {
+					t.Errorf("got range %q if %q", r.Header.Get("Range"), r.Header.Get("If-Range"))
+				}
+				partial(w, `"a"`, 5, video[5:])
+			},
+			expected: video,
+			requests: 1,
+		},
+		{
+			name:    "206 with a changed etag restarts",
+			partial: "abcde",
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				if request == 1 {
+					partial(w, `"b"`, 5, video[5:])
+					return
+				}
+				if r.Header.Get("Range") != "" {
+					t.Errorf("restart asked for range %q", r.Header.Get("Range"))
+				}
+				full(w, `"b"`, video)
+			},
+			expected: video,
+			requests: 2,
+		},
+		{
+			name:    "200 restarts from zero",
+			partial: "abcde",
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				full(w, `"b"`, video)
+			},
+			expected: video,
+			requests: 1,
+		},
+		{
+			name:    "416 on a complete partial file is accepted",
+			partial: video,
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				w.WriteHeader(416)
+			},
+			expected: video,
+			requests: 1,
+		},
+		{
+			name:    "416 on an incomplete partial file restarts",
+			partial: video[:5],
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				if request == 1 {
+					w.WriteHeader(416)
+					return
+				}
+				full(w, `"a"`, video)
+			},
+			expected: video,
+			requests: 2,
+		},
+		{
+			name:    "206 short of the content length is rejected",
+			partial: video[:5],
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				partial(w, `"a"`, 5, video[5:8])
+			},
+			requests: 1,
+			err:      ErrTruncated,
+		},
+		{
+			name: "200 short of the content length is rejected",
+			handler: func(w http.ResponseWriter, r *http.Request, request int) {
+				w.Header().Set("Content-Length", fmt.Sprintf("%d", len(video)))
+				w.Write([]byte(video[:5]))
+				// hijacking drops the connection without the rest of the body
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+			requests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests += 1
+				request := requests
+				mu.Unlock()
+				test.handler(w, r, request)
+			}))
+			defer server.Close()
+
+			url := server.URL + "/clip.mp4"
+			filename := filepath.Join(t.TempDir(), "clip.mp4")
+			if test.partial != "" {
+				writeTestFile(t, filename+".tmp", []byte(test.partial))
+				err := golib.SaveFile(filename+".tmp.resume", resumeState{URL: url, ETag: `"a"`, Size: int64(len(video))})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			clip := Clip{nest: &Nest{}, ID: 1}
+			err := clip.saveURL(url, filename, nil, nil, SaveOptions{})
+			mu.Lock()
+			defer mu.Unlock()
+			if requests != test.requests {
+				t.Errorf("got %d requests, expected %d", requests, test.requests)
+			}
+
+			if test.expected == "" {
+				if err == nil {
+					t.Fatal("download succeeded")
+				}
+				if test.err != nil && !errors.Is(err, test.err) {
+					t.Errorf("got %v, expected %v", err, test.err)
+				}
+				if FileExists(filename) {
+					t.Error("a failed download was moved into place")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != test.expected {
+				t.Errorf("got %q, expected %q", data, test.expected)
+			}
+			for _, leftover := range []string{filename + ".tmp", filename + ".tmp.resume"} {
+				if _, err := os.Stat(leftover); err == nil {
+					t.Errorf("%s was left behind", filepath.Base(leftover))
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupPartialDownloads(t *testing.T) {
+	directory := t.TempDir()
+	err := os.Mkdir(filepath.Join(directory, "videos"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-time.Hour)
+	write := func(name string, data string, modified time.Time) string {
+		filename := filepath.Join(directory, name)
+		writeTestFile(t, filename, []byte(data))
+		err := os.Chtimes(filename, modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	resume := func(name string, size int64, modified time.Time) {
+		filename := filepath.Join(directory, name)
+		err := golib.SaveFile(filename, resumeState{URL: "https://clips.example/1.mp4", ETag: `"a"`, Size: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(filename, modified, modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed := []string{
+		write("videos/stale.mp4.tmp", "0123", stale),
+		write("videos/overgrown.mp4.tmp", "0123456789", stale),
+		write("videos/orphan.mp4.tmp.resume", "{}", stale),
+		write("videos/stale.jpg.tmp", "0123", stale),
+		write("videos/clip.mp4.rewrite", "0123", stale),
+		write("videos/clip.mp4.tmp.rewrite", "0123", stale),
+	}
+	resume("videos/overgrown.mp4.tmp.resume", 5, stale)
+	resumable := []string{write("videos/resumable.mp4.tmp", "0123", stale)}
+	resume("videos/resumable.mp4.tmp.resume", 10, stale)
+	kept := []string{
+		filepath.Join(directory, "videos/resumable.mp4.tmp.resume"),
+		write("videos/fresh.mp4.tmp", "0123", time.Now()),
+		write("videos/fresh.mp4.tmp.rewrite", "0123", time.Now()),
+		write("videos/clip.mp4", "0123", stale),
+		write("videos/notes.txt.tmp", "0123", stale),
+	}
+
+	result, err := CleanupPartialDownloads(directory, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	sort.Strings(result.Removed)
+	if !reflect.DeepEqual(result.Removed, removed) {
+		t.Errorf("removed %v, expected %v", result.Removed, removed)
+	}
+	if !reflect.DeepEqual(result.Resumable, resumable) {
+		t.Errorf("got resumable %v, expected %v", result.Resumable, resumable)
+	}
+	for _, filename := range removed {
+		if FileExists(filename) {
+			t.Errorf("%s was not removed", filename)
+		}
+	}
+	if FileExists(filepath.Join(directory, "videos/overgrown.mp4.tmp.resume")) {
+		t.Error("the resume state of a removed partial was left behind")
+	}
+	for _, filename := range append(kept, resumable...) {
+		if !FileExists(filename) {
+			t.Errorf("%s was removed", filename)
+		}
+	}
+
+	// a directory which isn't there has nothing to clean up
+	_, err = CleanupPartialDownloads(filepath.Join(directory, "missing"), time.Minute)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCleanupPartialDownloadsInProgress(t *testing.T) {
+	video := testVideo(t)
+	half := len(video) / 2
+	written := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no etag, so the download has no resume state to be recognised by
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(video)))
+		w.Write(video[:half])
+		w.(http.Flusher).Flush()
+		close(written)
+		<-release
+		w.Write(video[half:])
+	}))
+	defer server.Close()
+
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "clip.mp4")
+	clip := Clip{nest: &Nest{}, ID: 1, DownloadURL: server.URL + "/1.mp4"}
+	done := make(chan error)
+	go func() {
+		done <- clip.Save(filename)
+	}()
+
+	<-written
+	// the partial is being written, wait for some of it to land on disk
+	for i := 0; ; i++ {
+		info, err := os.Stat(filename + ".tmp")
+		if err == nil && info.Size() > 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("the download never wrote its partial file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := CleanupPartialDownloads(directory, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 0 {
+		t.Errorf("removed %v while it was being downloaded", result.Removed)
+	}
+
+	close(release)
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, video) {
+		t.Error("the download was damaged by the cleanup")
+	}
+}
@@ -0,0 +1,311 @@
+package gonest
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type DownloadStatus string
+
+const (
+	DownloadSaved   DownloadStatus = "saved"
+	DownloadSkipped DownloadStatus = "skipped"
+	DownloadFailed  DownloadStatus = "failed"
+)
+
+type DownloadJob struct {
+	Clip     *Clip
+	Filename string
+	// Thumbnail, if set, is where the clip thumbnail is saved
+	Thumbnail string
+
+	// log is set by the Downloader while the job runs so everything logged
+	// for it, including by Skip, stays together
+	log log.FieldLogger
+}
+
+func (j DownloadJob) logger() log.FieldLogger {
+	if j.log == nil {
+		return log.StandardLogger()
+	}
+	return j.log
+}
+
+type DownloadResult struct {
+	Job      DownloadJob
+	Status   DownloadStatus
+	Error    error
+	Duration time.Duration
+
+	output *jobLog
+}
+
+type DownloadSummary struct {
+	Saved   int
+	Skipped int
+	Failed  int
+	Results []DownloadResult
+}
+
+// Downloader saves many clips at once with a bounded number of workers
+type Downloader struct {
+	// Workers is the number of clips downloaded at once, defaults to 1
+	Workers int
+	// PerHost limits concurrent downloads from a single clip server, 0 means
+	// only Workers applies
+	PerHost int
+	// RequestsPerSecond limits how quickly downloads are started across all
+	// workers, 0 means no limit
+	RequestsPerSecond float64
+	// Skip decides if a job is already done, by default jobs whose files
+	// already exist and pass verification are skipped. Jobs still missing a
+	// sidecar asked for in Options are never skipped.
+	Skip func(DownloadJob) bool
+	// Result is called once per job, in the order the jobs were given,
+	// regardless of the order in which they finish. Whatever was logged for
+	// the job is written just before, so parallel jobs never interleave.
+	Result func(DownloadResult)
+	// Options are passed on to Clip.SaveWithOptions for every video
+	Options SaveOptions
+
+	hostMu   sync.Mutex
+	hosts    map[string]chan struct{}
+	rateMu   sync.Mutex
+	nextSlot time.Time
+}
+
+func (d *Downloader) Run(jobs []DownloadJob) DownloadSummary {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	skip := d.Skip
+	if skip == nil {
+		skip = JobComplete
+	}
+
+	results := make([]DownloadResult, len(jobs))
+	finished := make(chan int)
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				logger, output := newJobLogger()
+				job := jobs[i]
+				job.log = logger
+				if skip(job) && !d.Options.SidecarMissing(job.Filename) {
+					results[i] = DownloadResult{Job: job, Status: DownloadSkipped}
+				} else {
+					results[i] = d.download(job)
+				}
+				results[i].output = output
+				finished <- i
+			}
+		}()
+	}
+
+	go func() {
+		for i := range jobs {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+		close(finished)
+	}()
+
+	// hand results out in job order, holding back any that finish early
+	var summary DownloadSummary
+	done := make([]bool, len(jobs))
+	next := 0
+	for i := range finished {
+		done[i] = true
+		for next < len(jobs) && done[next] {
+			result := results[next]
+			result.output.replay()
+			switch result.Status {
+			case DownloadSaved:
+				summary.Saved += 1
+			case DownloadSkipped:
+				summary.Skipped += 1
+			case DownloadFailed:
+				summary.Failed += 1
+			}
+			if d.Result != nil {
+				d.Result(result)
+			}
+			next += 1
+		}
+	}
+	summary.Results = results
+
+	return summary
+}
+
+func (d *Downloader) download(job DownloadJob) DownloadResult {
+	started := time.Now()
+	result := DownloadResult{Job: job, Status: DownloadSaved}
+
+	release := d.acquireHost(job.Clip.DownloadURL)
+	defer release()
+
+	opts := d.Options
+	opts.Log = job.logger()
+	if !videoComplete(job) {
+		d.wait()
+		result.Error = job.Clip.SaveWithOptions(job.Filename, opts)
+	} else if opts.SidecarMissing(job.Filename) {
+		result.Error = job.Clip.writeSidecar(job.Filename, opts.Log)
+	}
+	if result.Error == nil && job.Thumbnail != "" && !FileExists(job.Thumbnail) {
+		d.wait()
+		result.Error = job.Clip.saveThumbnail(job.Thumbnail, SaveOptions{RateLimit: opts.RateLimit, Log: opts.Log})
+	}
+	if result.Error != nil {
+		result.Status = DownloadFailed
+		job.logger().WithFields(log.Fields{
+			"id":       job.Clip.ID,
+			"filename": job.Filename,
+			"error":    result.Error,
+		}).Debug("download failed")
+	}
+
+	result.Duration = time.Since(started)
+	return result
+}
+
+// acquireHost blocks until fewer than PerHost downloads are running against
+// the host serving rawurl and returns a func to release the slot
+func (d *Downloader) acquireHost(rawurl string) func() {
+	if d.PerHost < 1 {
+		return func() {}
+	}
+
+	host := rawurl
+	parsed, err := url.Parse(rawurl)
+	if err == nil {
+		host = parsed.Host
+	}
+
+	d.hostMu.Lock()
+	if d.hosts == nil {
+		d.hosts = make(map[string]chan struct{})
+	}
+	slots, ok := d.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, d.PerHost)
+		d.hosts[host] = slots
+	}
+	d.hostMu.Unlock()
+
+	slots <- struct{}{}
+	return func() {
+		<-slots
+	}
+}
+
+// wait spaces request starts out to RequestsPerSecond across all workers
+func (d *Downloader) wait() {
+	if d.RequestsPerSecond <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / d.RequestsPerSecond)
+
+	d.rateMu.Lock()
+	now := time.Now()
+	if d.nextSlot.Before(now) {
+		d.nextSlot = now
+	}
+	slot := d.nextSlot
+	d.nextSlot = d.nextSlot.Add(interval)
+	d.rateMu.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
+
+// JobComplete is the default Skip, it checks the files of job exist and the
+// video passes verification
+func JobComplete(job DownloadJob) bool {
+	if !videoComplete(job) {
+		return false
+	}
+	return job.Thumbnail == "" || FileExists(job.Thumbnail)
+}
+
+func videoComplete(job DownloadJob) bool {
+	if !FileExists(job.Filename) {
+		return false
+	}
+	err := job.Clip.Verify(job.Filename)
+	if err != nil {
+		job.logger().WithFields(log.Fields{
+			"id":       job.Clip.ID,
+			"filename": job.Filename,
+			"error":    err,
+		}).Warn("existing file failed verification, downloading again")
+		return false
+	}
+	return true
+}
+
+// jobLog holds what was logged for one job until the collector replays it
+// through the standard logger in job order
+type jobLog struct {
+	mu      sync.Mutex
+	entries []*log.Entry
+}
+
+func newJobLogger() (*log.Logger, *jobLog) {
+	output := &jobLog{}
+	logger := log.New()
+	logger.Out = ioutil.Discard
+	logger.SetLevel(log.GetLevel())
+	logger.AddHook(output)
+	return logger, output
+}
+
+func (l *jobLog) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (l *jobLog) Fire(entry *log.Entry) error {
+	data := make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, &log.Entry{
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: entry.Message,
+		Data:    data,
+	})
+	return nil
+}
+
+func (l *jobLog) replay() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		log.WithFields(entry.Data).WithTime(entry.Time).Log(entry.Level, entry.Message)
+	}
+	l.entries = nil
+}
+
+// FileExists reports if filename can be stat'd
+func FileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
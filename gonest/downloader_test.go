@@ -0,0 +1,196 @@
+package gonest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testVideo returns a small mp4 which passes VerifyMP4
+func testVideo(t *testing.T) []byte {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "video.mp4")
+	writeTestMP4(t, filename, false, testTrack{Handler: "vide", Timescale: 1000, Delta: 1000, Sizes: make([]uint32, 30)})
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testJobs returns count jobs for clips served by server at /<index>
+func testJobs(t *testing.T, server *httptest.Server, count int) []DownloadJob {
+	directory := t.TempDir()
+	var jobs []DownloadJob
+	for i := 0; i < count; i++ {
+		jobs = append(jobs, DownloadJob{
+			Clip:     &Clip{nest: &Nest{}, ID: i, DownloadURL: fmt.Sprintf("%s/%d", server.URL, i)},
+			Filename: filepath.Join(directory, fmt.Sprintf("%d.mp4", i)),
+		})
+	}
+	return jobs
+}
+
+func requestIndex(r *http.Request) int {
+	index, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+	return index
+}
+
+func TestDownloaderOrder(t *testing.T) {
+	video := testVideo(t)
+	var mu sync.Mutex
+	var finished []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// earlier jobs take longer so they finish last
+		index := requestIndex(r)
+		time.Sleep(time.Duration(4-index) * 20 * time.Millisecond)
+		w.Write(video)
+		mu.Lock()
+		finished = append(finished, index)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var results []int
+	downloader := Downloader{
+		Workers: 4,
+		Result: func(result DownloadResult) {
+			if result.Status != DownloadSaved {
+				t.Errorf("job %d got %s: %v", result.Job.Clip.ID, result.Status, result.Error)
+			}
+			results = append(results, result.Job.Clip.ID)
+		},
+	}
+	summary := downloader.Run(testJobs(t, server, 4))
+
+	if !reflect.DeepEqual(results, []int{0, 1, 2, 3}) {
+		t.Errorf("got results in order %v, expected job order", results)
+	}
+	if reflect.DeepEqual(finished, []int{0, 1, 2, 3}) {
+		t.Errorf("jobs finished in job order %v, the test didn't run them in parallel", finished)
+	}
+	if summary.Saved != 4 || len(summary.Results) != 4 {
+		t.Errorf("got %+v", summary)
+	}
+}
+
+func TestDownloaderPerHost(t *testing.T) {
+	video := testVideo(t)
+	var mu sync.Mutex
+	running := 0
+	most := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running += 1
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		w.Write(video)
+		mu.Lock()
+		running -= 1
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	downloader := Downloader{Workers: 6, PerHost: 2}
+	summary := downloader.Run(testJobs(t, server, 6))
+	if summary.Saved != 6 {
+		t.Fatalf("got %+v", summary)
+	}
+	if most != 2 {
+		t.Errorf("got at most %d downloads at once from the host, expected 2", most)
+	}
+}
+
+func TestDownloaderRequestsPerSecond(t *testing.T) {
+	video := testVideo(t)
+	var mu sync.Mutex
+	var started []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		started = append(started, time.Now())
+		mu.Unlock()
+		w.Write(video)
+	}))
+	defer server.Close()
+
+	downloader := Downloader{Workers: 4, RequestsPerSecond: 20}
+	summary := downloader.Run(testJobs(t, server, 4))
+	if summary.Saved != 4 {
+		t.Fatalf("got %+v", summary)
+	}
+
+	sort.Slice(started, func(i, j int) bool {
+		return started[i].Before(started[j])
+	})
+	for i := 1; i < len(started); i++ {
+		// a little slack for the time between the slot and the request
+		if gap := started[i].Sub(started[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("request %d started %s after the one before, expected 50ms", i, gap)
+		}
+	}
+}
+
+func TestDownloaderSkip(t *testing.T) {
+	video := testVideo(t)
+	var mu sync.Mutex
+	fetched := make(map[int]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched[requestIndex(r)] = true
+		mu.Unlock()
+		w.Write(video)
+	}))
+	defer server.Close()
+
+	// 0 is complete, 1 is there but broken, 2 is missing
+	jobs := testJobs(t, server, 3)
+	writeTestFile(t, jobs[0].Filename, video)
+	writeTestFile(t, jobs[1].Filename, []byte("not a video"))
+
+	var statuses []DownloadStatus
+	downloader := Downloader{
+		Workers: 3,
+		Result: func(result DownloadResult) {
+			statuses = append(statuses, result.Status)
+		},
+	}
+	summary := downloader.Run(jobs)
+
+	expected := []DownloadStatus{DownloadSkipped, DownloadSaved, DownloadSaved}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("got %v, expected %v", statuses, expected)
+	}
+	if !reflect.DeepEqual(fetched, map[int]bool{1: true, 2: true}) {
+		t.Errorf("got fetched %v, expected only the broken and missing clips", fetched)
+	}
+	if summary.Skipped != 1 || summary.Saved != 2 || summary.Failed != 0 {
+		t.Errorf("got %+v", summary)
+	}
+
+	// a custom Skip replaces the check on the files
+	fetched = make(map[int]bool)
+	downloader = Downloader{
+		Skip: func(job DownloadJob) bool {
+			return job.Clip.ID != 2
+		},
+	}
+	summary = downloader.Run(testJobs(t, server, 3))
+	if !reflect.DeepEqual(fetched, map[int]bool{2: true}) {
+		t.Errorf("got fetched %v, expected only the clip Skip let through", fetched)
+	}
+	if summary.Skipped != 2 || summary.Saved != 1 {
+		t.Errorf("got %+v", summary)
+	}
+}
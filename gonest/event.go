@@ -0,0 +1,165 @@
+package gonest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Event is a cuepoint in the camera history, such as motion or a person
+type Event struct {
+	StartTimeFloat float64  `json:"start_time"`
+	EndTimeFloat   float64  `json:"end_time"`
+	Types          []string `json:"types"`
+	InProgress     bool     `json:"in_progress"`
+}
+
+// eventTime accepts both seconds and milliseconds since the epoch
+func eventTime(t float64) time.Time {
+	if t > 1e11 {
+		t = t / 1000
+	}
+	sec, dec := math.Modf(t)
+	return time.Unix(int64(sec), int64(dec*(1e9)))
+}
+
+func (e Event) StartTime() time.Time {
+	return eventTime(e.StartTimeFloat)
+}
+
+// EndTime of an event which is still going on is now
+func (e Event) EndTime() time.Time {
+	if e.EndTimeFloat == 0 || e.InProgress {
+		return time.Now()
+	}
+	return eventTime(e.EndTimeFloat)
+}
+
+// HasType reports if the event has any of types, no types matches everything
+func (e Event) HasType(types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, want := range types {
+		for _, have := range e.Types {
+			if strings.EqualFold(want, have) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// https://nexusapi-us1.camera.home.nest.com/cuepoint/2cb461328c9b4c5087dfb11cd2131a6c/2?start_time=1600000000000&end_time=1600003600000
+func (c *Camera) Events(r TimeRange) ([]Event, error) {
+	server := c.NexusAPIHTTPServer
+	if server == "" {
+		return nil, fmt.Errorf("camera %s has no nexus api server", c.UUID)
+	}
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	url := fmt.Sprintf("%s/cuepoint/%s/2?start_time=%d&end_time=%d", strings.TrimSuffix(server, "/"), c.UUID, r.Start.UnixNano()/int64(time.Millisecond), r.End.UnixNano()/int64(time.Millisecond))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Origin", "https://home.nest.com")
+	req.Header.Add("Referer", "https://home.nest.com/")
+	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", c.nest.CZToken))
+	req.Header.Add("Cookie", fmt.Sprintf("n=%s; user_token=%s", c.nest.N, c.nest.UserToken))
+
+	resp, err := c.nest.httpClient.Do(req)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"url":   url,
+			"error": err,
+		}).Error("cuepoint request failed")
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		log.WithFields(log.Fields{
+			"url":    url,
+			"status": resp.Status,
+		}).Error("cuepoint request returned an invalid status code")
+		return nil, errors.New("invalid status code returned")
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	err = json.Unmarshal(body, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CameraEvents looks up camera uuid and returns its events during r
+func (n *Nest) CameraEvents(uuid string, r TimeRange) ([]Event, error) {
+	cameras, err := n.ListCameras()
+	if err != nil {
+		return nil, err
+	}
+	for _, camera := range cameras {
+		if camera.UUID == uuid {
+			return camera.Events(r)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// EventIntervals turns events into the smallest set of ranges inside within
+// that covers every event of the wanted types, padded by pre and post.
+// Ranges closer together than join are merged as well.
+func EventIntervals(events []Event, types []string, pre time.Duration, post time.Duration, join time.Duration, within TimeRange) []TimeRange {
+	var ranges []TimeRange
+	for _, event := range events {
+		if !event.HasType(types) {
+			continue
+		}
+		// clips are requested in whole seconds, so round outwards
+		r := TimeRange{
+			Start: event.StartTime().Add(-pre).Truncate(time.Second),
+			End:   event.EndTime().Add(post + time.Second - 1).Truncate(time.Second),
+		}
+		if r.Start.Before(within.Start) {
+			r.Start = within.Start
+		}
+		if r.End.After(within.End) {
+			r.End = within.End
+		}
+		if r.End.After(r.Start) {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start.Before(ranges[j].Start)
+	})
+
+	var merged []TimeRange
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && !r.Start.After(merged[last].End.Add(join)) {
+			if r.End.After(merged[last].End) {
+				merged[last].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
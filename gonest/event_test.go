@@ -0,0 +1,141 @@
+package gonest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventIntervals(t *testing.T) {
+	const base = 1700000000
+	at := func(seconds float64) time.Time {
+		return eventTime(base + seconds)
+	}
+	span := func(start, end float64) TimeRange {
+		return TimeRange{Start: at(start), End: at(end)}
+	}
+	event := func(start, end float64, types ...string) Event {
+		return Event{StartTimeFloat: base + start, EndTimeFloat: base + end, Types: types}
+	}
+	hour := span(0, 3600)
+
+	tests := []struct {
+		name     string
+		events   []Event
+		types    []string
+		pre      time.Duration
+		post     time.Duration
+		join     time.Duration
+		within   TimeRange
+		expected []TimeRange
+	}{
+		{
+			name:     "nothing",
+			within:   hour,
+			expected: nil,
+		},
+		{
+			name:     "padded",
+			events:   []Event{event(100, 110)},
+			pre:      5 * time.Second,
+			post:     10 * time.Second,
+			within:   hour,
+			expected: []TimeRange{span(95, 120)},
+		},
+		{
+			name:     "rounded out to whole seconds",
+			events:   []Event{event(100.4, 110.2)},
+			within:   hour,
+			expected: []TimeRange{span(100, 111)},
+		},
+		{
+			name: "milliseconds",
+			events: []Event{{
+				StartTimeFloat: (base + 100) * 1000,
+				EndTimeFloat:   (base + 110) * 1000,
+			}},
+			pre:      5 * time.Second,
+			post:     10 * time.Second,
+			within:   hour,
+			expected: []TimeRange{span(95, 120)},
+		},
+		{
+			name:     "overlapping after padding",
+			events:   []Event{event(100, 110), event(115, 120)},
+			post:     10 * time.Second,
+			within:   hour,
+			expected: []TimeRange{span(100, 130)},
+		},
+		{
+			name:     "contained",
+			events:   []Event{event(100, 200), event(120, 130)},
+			within:   hour,
+			expected: []TimeRange{span(100, 200)},
+		},
+		{
+			name:     "further apart than join",
+			events:   []Event{event(100, 110), event(125, 130)},
+			join:     10 * time.Second,
+			within:   hour,
+			expected: []TimeRange{span(100, 110), span(125, 130)},
+		},
+		{
+			name:     "closer together than join",
+			events:   []Event{event(100, 110), event(125, 130)},
+			join:     15 * time.Second,
+			within:   hour,
+			expected: []TimeRange{span(100, 130)},
+		},
+		{
+			name:     "unsorted",
+			events:   []Event{event(300, 310), event(100, 110), event(200, 210)},
+			within:   hour,
+			expected: []TimeRange{span(100, 110), span(200, 210), span(300, 310)},
+		},
+		{
+			name:     "types",
+			events:   []Event{event(100, 110, "motion"), event(200, 210, "Person"), event(300, 310, "sound", "person")},
+			types:    []string{"person"},
+			within:   hour,
+			expected: []TimeRange{span(200, 210), span(300, 310)},
+		},
+		{
+			name:     "clamped to within",
+			events:   []Event{event(90, 105), event(195, 210), event(300, 310)},
+			pre:      5 * time.Second,
+			within:   span(100, 200),
+			expected: []TimeRange{span(100, 105), span(190, 200)},
+		},
+		{
+			name:     "in progress",
+			events:   []Event{{StartTimeFloat: base + 150, InProgress: true}},
+			within:   span(100, 200),
+			expected: []TimeRange{span(150, 200)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := EventIntervals(test.events, test.types, test.pre, test.post, test.join, test.within)
+			if !equalRanges(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	tests := []struct {
+		input    float64
+		expected time.Time
+	}{
+		{input: 1700000000, expected: time.Unix(1700000000, 0)},
+		{input: 1700000000.5, expected: time.Unix(1700000000, 500000000)},
+		{input: 1700000000000, expected: time.Unix(1700000000, 0)},
+		{input: 1700000000250, expected: time.Unix(1700000000, 250000000)},
+	}
+	for _, test := range tests {
+		got := eventTime(test.input)
+		if diff := got.Sub(test.expected); diff > time.Microsecond || diff < -time.Microsecond {
+			t.Errorf("%f: got %s, expected %s", test.input, got, test.expected)
+		}
+	}
+}
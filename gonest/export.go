@@ -0,0 +1,253 @@
+package gonest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ExportResult struct {
+	Segment  TimeRange
+	Clip     *Clip
+	Filename string
+	Error    error
+	// Deleted is set once the clip has been removed from the server
+	Deleted bool
+	// Kept is set when KeepClips left a saved clip on the server
+	Kept     bool
+	Duration time.Duration
+}
+
+// Exporter turns a time range of camera history into files by creating a
+// clip per segment, waiting for it, downloading it and deleting it again.
+// Several clips are kept in flight so the server generates the next clips
+// while earlier ones download. Clips are only deleted once their download
+// has been verified, unless DeleteOnFailure is set.
+type Exporter struct {
+	// Concurrency is the number of clips in flight at once, defaults to 2
+	Concurrency int
+	// QuotaRetries is how often a refused clip request is retried while no
+	// other clip is in flight, defaults to 5
+	QuotaRetries int
+	// QuotaBackoff is the wait between those retries, defaults to 30 seconds
+	QuotaBackoff time.Duration
+	// Filename returns where a segment is saved and is required
+	Filename func(segment TimeRange, clip *Clip) string
+	// Reuse holds clips requested earlier, such as by an interrupted run,
+	// keyed by the unix start time of their segment
+	Reuse map[int64]*Clip
+	// Created, if set, is called as soon as a clip has been requested
+	Created func(segment TimeRange, clip *Clip)
+	// KeepClips leaves every clip on the server after saving it
+	KeepClips bool
+	// DeleteOnFailure deletes clips whose download failed as well, which
+	// loses the only copy of that video if the server has already dropped it
+	DeleteOnFailure bool
+	// Result is called once per segment, in segment order
+	Result  func(ExportResult)
+	Wait    WaitReadyOptions
+	Options SaveOptions
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	waiter *clipWaiter
+}
+
+func (e *Exporter) withDefaults() {
+	if e.Concurrency < 1 {
+		e.Concurrency = 2
+	}
+	if e.QuotaRetries == 0 {
+		e.QuotaRetries = 5
+	}
+	if e.QuotaBackoff == 0 {
+		e.QuotaBackoff = 30 * time.Second
+	}
+	e.cond = sync.NewCond(&e.mu)
+}
+
+// Export runs the pipeline for every segment of camera uuid and returns the
+// results in segment order. Clips in flight are waited on together, with one
+// poll of the server per WaitReadyOptions.Interval.
+func (n *Nest) Export(ctx context.Context, uuid string, segments []TimeRange, e *Exporter) ([]ExportResult, error) {
+	if e.Filename == nil {
+		return nil, errors.New("exporter has no Filename func")
+	}
+	e.withDefaults()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.waiter = n.newClipWaiter(ctx, e.Wait)
+
+	results := make([]ExportResult, len(segments))
+	finished := make(chan int)
+
+	var wg sync.WaitGroup
+	go func() {
+		for i, segment := range segments {
+			results[i].Segment = segment
+			if ctx.Err() != nil {
+				results[i].Error = ctx.Err()
+				finished <- i
+				continue
+			}
+
+			started := time.Now()
+			clip, err := e.create(ctx, n, uuid, segment)
+			if err != nil {
+				results[i].Error = err
+				results[i].Duration = time.Since(started)
+				finished <- i
+				continue
+			}
+			if e.Created != nil {
+				e.Created(segment, clip)
+			}
+
+			wg.Add(1)
+			go func(i int, clip *Clip) {
+				defer wg.Done()
+				e.export(clip, &results[i])
+				results[i].Duration = time.Since(started)
+				e.release()
+				finished <- i
+			}(i, clip)
+		}
+		wg.Wait()
+		close(finished)
+	}()
+
+	// hand results out in segment order, holding back any that finish early
+	done := make([]bool, len(segments))
+	next := 0
+	for i := range finished {
+		done[i] = true
+		for next < len(segments) && done[next] {
+			if e.Result != nil {
+				e.Result(results[next])
+			}
+			next += 1
+		}
+	}
+	return results, nil
+}
+
+// create waits for a free slot and requests the clip. When the server refuses
+// a request and other clips are in flight we assume the clip quota is full
+// and wait for one of them to be deleted before asking again.
+func (e *Exporter) create(ctx context.Context, n *Nest, uuid string, segment TimeRange) (*Clip, error) {
+	e.mu.Lock()
+	for e.active >= e.Concurrency {
+		e.cond.Wait()
+	}
+	e.active += 1
+	e.mu.Unlock()
+
+	clip := e.Reuse[segment.Start.Unix()]
+	if clip != nil {
+		return clip, nil
+	}
+
+	length := int(segment.Length() / time.Second)
+	if length < 1 {
+		e.release()
+		return nil, fmt.Errorf("segment %s is shorter than a second", segment)
+	}
+
+	retries := 0
+	for {
+		clip, err := n.CreateClip(uuid, segment.Start, length)
+		if err == nil {
+			return clip, nil
+		}
+
+		var apiError *APIError
+		if !errors.As(err, &apiError) {
+			e.release()
+			return nil, err
+		}
+
+		e.mu.Lock()
+		others := e.active - 1
+		e.mu.Unlock()
+
+		log.WithFields(log.Fields{
+			"segment":   segment,
+			"in_flight": others,
+			"error":     err,
+		}).Info("clip request refused, waiting for quota")
+
+		if others > 0 {
+			// give the slot back until another clip finishes
+			e.mu.Lock()
+			e.active -= 1
+			current := e.active
+			for e.active >= current && e.active > 0 {
+				e.cond.Wait()
+			}
+			e.active += 1
+			e.mu.Unlock()
+			continue
+		}
+
+		retries += 1
+		if retries > e.QuotaRetries {
+			e.release()
+			return nil, err
+		}
+		err = sleepContext(ctx, e.QuotaBackoff)
+		if err != nil {
+			e.release()
+			return nil, err
+		}
+	}
+}
+
+func (e *Exporter) release() {
+	e.mu.Lock()
+	e.active -= 1
+	e.mu.Unlock()
+	e.cond.Broadcast()
+}
+
+func (e *Exporter) export(clip *Clip, result *ExportResult) {
+	result.Clip = clip
+
+	copied := *clip
+	ready := &copied
+	err := e.waiter.Wait(ready)
+	if err != nil {
+		result.Error = err
+	} else {
+		result.Clip = ready
+		result.Filename = e.Filename(result.Segment, ready)
+		result.Error = ready.SaveWithOptions(result.Filename, e.Options)
+	}
+
+	if result.Error == nil && e.KeepClips {
+		result.Kept = true
+		return
+	}
+	if result.Error != nil && !e.DeleteOnFailure {
+		log.WithFields(log.Fields{
+			"id":    clip.ID,
+			"error": result.Error,
+		}).Warn("leaving clip on the server after failure")
+		return
+	}
+
+	err = clip.Delete()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    clip.ID,
+			"error": err,
+		}).Error("failed deleting clip")
+		return
+	}
+	result.Deleted = true
+}
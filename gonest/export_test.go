@@ -0,0 +1,187 @@
+package gonest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSegments returns count consecutive 30 second segments
+func testSegments(count int) []TimeRange {
+	start := time.Unix(1700000000, 0)
+	var segments []TimeRange
+	for i := 0; i < count; i++ {
+		segments = append(segments, TimeRange{Start: start, End: start.Add(30 * time.Second)})
+		start = start.Add(30 * time.Second)
+	}
+	return segments
+}
+
+func testExporter(t *testing.T) *Exporter {
+	directory := t.TempDir()
+	return &Exporter{
+		QuotaBackoff: time.Millisecond,
+		Filename: func(segment TimeRange, clip *Clip) string {
+			return filepath.Join(directory, fmt.Sprintf("%d.mp4", clip.ID))
+		},
+		Wait: WaitReadyOptions{
+			Timeout:  time.Second,
+			Interval: time.Millisecond,
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	api, nest := newFakeAPI(t)
+	api.video = testVideo(t)
+	// only two clips fit on the server, so the third clip in flight is
+	// refused until one of the others is deleted
+	api.quota = 2
+	api.pending = true
+	api.poll = func(polls int, clips []*Clip) {
+		for _, clip := range clips {
+			clip.IsGenerated = polls >= 3
+		}
+	}
+
+	exporter := testExporter(t)
+	exporter.Concurrency = 3
+	var unsaved []int
+	api.deleting = func(id int) {
+		// a clip is only deleted once its video is in place
+		if !FileExists(exporter.Filename(TimeRange{}, &Clip{ID: id})) {
+			unsaved = append(unsaved, id)
+		}
+	}
+	var order []int
+	exporter.Result = func(result ExportResult) {
+		order = append(order, int(result.Segment.Start.Unix()))
+	}
+
+	segments := testSegments(5)
+	results, err := nest.Export(context.Background(), "abc123", segments, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected []int
+	for i, result := range results {
+		expected = append(expected, int(segments[i].Start.Unix()))
+		if result.Error != nil || !result.Deleted || !FileExists(result.Filename) {
+			t.Errorf("segment %d got %+v", i, result)
+		}
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("got results for %v, expected segment order %v", order, expected)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.most != 2 {
+		t.Errorf("got at most %d clips on the server, expected the quota of 2", api.most)
+	}
+	if api.requests["/api/clips.request"] <= len(segments) {
+		t.Error("no clip request was refused, the test didn't fill the quota")
+	}
+	if len(api.deleted) != len(segments) || len(api.clips) != 0 {
+		t.Errorf("deleted %v, left %d clips", api.deleted, len(api.clips))
+	}
+	if len(unsaved) > 0 {
+		t.Errorf("clips %v were deleted before they were saved", unsaved)
+	}
+	// clips waiting together share the clip list
+	if api.requests["/api/clips.get_visible_with_quota"] == 0 {
+		t.Error("no poll was shared between clips in flight")
+	}
+}
+
+func TestExportQuotaRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		refuse   int
+		retries  int
+		requests int
+		failed   bool
+	}{
+		{
+			name:     "refused then accepted",
+			refuse:   2,
+			retries:  5,
+			requests: 3,
+		},
+		{
+			name:     "refused too often",
+			refuse:   5,
+			retries:  1,
+			requests: 2,
+			failed:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api, nest := newFakeAPI(t)
+			api.video = testVideo(t)
+			api.refuse = test.refuse
+
+			exporter := testExporter(t)
+			exporter.Concurrency = 1
+			exporter.QuotaRetries = test.retries
+			results, err := nest.Export(context.Background(), "abc123", testSegments(1), exporter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if requests := api.count("/api/clips.request"); requests != test.requests {
+				t.Errorf("got %d clip requests, expected %d", requests, test.requests)
+			}
+			var apiError *APIError
+			if test.failed {
+				if !errors.As(results[0].Error, &apiError) {
+					t.Errorf("got %v, expected the refusal", results[0].Error)
+				}
+				return
+			}
+			if results[0].Error != nil || !results[0].Deleted {
+				t.Errorf("got %+v", results[0])
+			}
+		})
+	}
+}
+
+func TestExportSaveFailure(t *testing.T) {
+	for _, deleteOnFailure := range []bool{false, true} {
+		t.Run(fmt.Sprintf("delete on failure %t", deleteOnFailure), func(t *testing.T) {
+			api, nest := newFakeAPI(t)
+			// the download never verifies
+			api.video = []byte("not a video")
+
+			exporter := testExporter(t)
+			exporter.DeleteOnFailure = deleteOnFailure
+			results, err := nest.Export(context.Background(), "abc123", testSegments(1), exporter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := results[0]
+			if result.Error == nil {
+				t.Fatal("saving an invalid video succeeded")
+			}
+			if FileExists(result.Filename) {
+				t.Error("the invalid video was moved into place")
+			}
+			if result.Deleted != deleteOnFailure {
+				t.Errorf("got deleted %t, expected %t", result.Deleted, deleteOnFailure)
+			}
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			if (len(api.deleted) == 1) != deleteOnFailure || (len(api.clips) == 1) == deleteOnFailure {
+				t.Errorf("deleted %v and left %d clips on the server", api.deleted, len(api.clips))
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 )
 
 type LoginRequest struct {
@@ -23,19 +24,35 @@ type LoginRequest struct {
 
 type Nest struct {
 	httpClient http.Client
+	mu         sync.Mutex
 
 	DumpRawRequest  bool
 	DumpRawResponse bool
 
+	// Bandwidth, if set, limits every clip and thumbnail download
+	Bandwidth *BandwidthLimiter `json:"-"`
+
 	Email     string `json:"email"`
 	Password  string `json:"password"`
 	CZToken   string `json:"czToken"`
 	Website_2 string `json:"website2"`
 	N         string `json:"n"`
 	UserToken string `json:"user_token"`
+
+	// ArchiveRoot is the default directory for downloads and archives,
+	// relative directories given to commands are resolved against it
+	ArchiveRoot string `json:"archive_root,omitempty"`
+	// DirectoryMode is the octal permission for directories gonest creates
+	DirectoryMode string `json:"directory_mode,omitempty"`
+	// CameraDirectories saves clips in a subdirectory per camera
+	CameraDirectories bool `json:"camera_directories,omitempty"`
+
+	CreatedClips []int `json:"created_clips"`
 }
 
 func (n *Nest) Save() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
 	home := os.Getenv("HOME")
 	return golib.SaveFile(path.Join(home, ".gonest.json"), n)
 }
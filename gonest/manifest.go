@@ -0,0 +1,486 @@
+package gonest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+const ManifestFilename = ".gonest-manifest.json"
+
+type ManifestStatus string
+
+const (
+	ManifestComplete ManifestStatus = "complete"
+	ManifestFailed   ManifestStatus = "failed"
+	ManifestMissing  ManifestStatus = "missing"
+	// ManifestPruned entries were removed by the retention policy and are
+	// not downloaded again
+	ManifestPruned ManifestStatus = "pruned"
+)
+
+// ManifestSource is how a file came to be in the archive
+type ManifestSource string
+
+const (
+	// ManifestFromClips files are downloads of clips from the clip list
+	ManifestFromClips ManifestSource = "clips"
+	// ManifestFromExport files were exported from camera history through
+	// temporary clips which gonest deleted again
+	ManifestFromExport ManifestSource = "export"
+)
+
+type ManifestEntry struct {
+	ID              int            `json:"id"`
+	CameraUUID      string         `json:"camera_uuid"`
+	Title           string         `json:"title"`
+	Start           time.Time      `json:"start"`
+	End             time.Time      `json:"end"`
+	Path            string         `json:"path"`
+	Size            int64          `json:"size"`
+	SHA256          string         `json:"sha256"`
+	Status          ManifestStatus `json:"status"`
+	Error           string         `json:"error,omitempty"`
+	DownloadedAt    time.Time      `json:"downloaded_at"`
+	RemoteDeleted   bool           `json:"remote_deleted"`
+	RemoteDeletedAt time.Time      `json:"remote_deleted_at"`
+	// DeletedBySync is set when gonest deleted the clip from the server
+	// itself after archiving it, rather than someone removing it in the app
+	DeletedBySync bool `json:"deleted_by_sync"`
+	// Source is empty when it isn't known, such as for entries recorded
+	// before it was tracked
+	Source ManifestSource `json:"source,omitempty"`
+	// Joined entries are files joined from exported segments, they have no
+	// clip of their own and use negative ids
+	Joined bool `json:"joined,omitempty"`
+	// Keep protects the file from the retention policy
+	Keep     bool      `json:"keep,omitempty"`
+	PrunedAt time.Time `json:"pruned_at,omitempty"`
+}
+
+// Manifest records what has been downloaded into an archive directory, paths
+// are kept relative to the directory so it can be moved around
+type Manifest struct {
+	Directory string                 `json:"-"`
+	Entries   map[int]*ManifestEntry `json:"entries"`
+	LastSync  time.Time              `json:"last_sync"`
+
+	mu sync.Mutex
+}
+
+func LoadManifest(directory string) (*Manifest, error) {
+	m := &Manifest{
+		Directory: directory,
+		Entries:   make(map[int]*ManifestEntry),
+	}
+	err := golib.LoadFile(filepath.Join(directory, ManifestFilename), m)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if m.Entries == nil {
+		m.Entries = make(map[int]*ManifestEntry)
+	}
+	return m, nil
+}
+
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := mkdirAll(m.Directory)
+	if err != nil {
+		return err
+	}
+	return golib.SaveFile(filepath.Join(m.Directory, ManifestFilename), m)
+}
+
+// Get returns a copy of the entry for a clip id
+func (m *Manifest) Get(id int) (ManifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Entries[id]
+	if !ok {
+		return ManifestEntry{}, false
+	}
+	return *entry, true
+}
+
+func (m *Manifest) List() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []ManifestEntry
+	for _, entry := range m.Entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Start.Equal(entries[j].Start) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].Start.Before(entries[j].Start)
+	})
+	return entries
+}
+
+// FullPath turns a manifest path back into a usable filename
+func (m *Manifest) FullPath(entry ManifestEntry) string {
+	if filepath.IsAbs(entry.Path) {
+		return entry.Path
+	}
+	return filepath.Join(m.Directory, entry.Path)
+}
+
+func (m *Manifest) relative(filename string) string {
+	rel, err := filepath.Rel(m.Directory, filename)
+	if err != nil {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return filename
+		}
+		return abs
+	}
+	return rel
+}
+
+// Complete reports if the clip was downloaded and is still where the
+// manifest says it is
+func (m *Manifest) Complete(id int) bool {
+	entry, ok := m.Get(id)
+	if !ok || entry.Status != ManifestComplete {
+		return false
+	}
+	return FileExists(m.FullPath(entry))
+}
+
+func newManifestEntry(clip *Clip) *ManifestEntry {
+	start := clip.StartTime()
+	return &ManifestEntry{
+		ID:         clip.ID,
+		CameraUUID: clip.CameraUUID,
+		Title:      clip.Title,
+		Start:      start,
+		End:        start.Add(time.Duration(clip.Length * float64(time.Second))),
+	}
+}
+
+// Record adds a downloaded clip, hashing the file on the way
+func (m *Manifest) Record(clip *Clip, filename string, source ManifestSource) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		return err
+	}
+
+	entry := newManifestEntry(clip)
+	entry.Path = m.relative(filename)
+	entry.Size = info.Size()
+	entry.SHA256 = sum
+	entry.Status = ManifestComplete
+	entry.DownloadedAt = time.Now().UTC()
+	entry.Source = source
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries[clip.ID] = entry
+	return nil
+}
+
+// RecordJoined adds a file joined from exported segments of camera uuid
+// covering r. Joining the same file again keeps its id.
+func (m *Manifest) RecordJoined(uuid string, r TimeRange, filename string) (ManifestEntry, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	path := m.relative(filename)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := -1
+	for existing, entry := range m.Entries {
+		if entry.Joined && entry.Path == path {
+			id = existing
+			break
+		}
+		if existing <= id {
+			id = existing - 1
+		}
+	}
+	entry := &ManifestEntry{
+		ID:           id,
+		CameraUUID:   uuid,
+		Start:        r.Start,
+		End:          r.End,
+		Path:         path,
+		Size:         info.Size(),
+		SHA256:       sum,
+		Status:       ManifestComplete,
+		DownloadedAt: time.Now().UTC(),
+		Source:       ManifestFromExport,
+		Joined:       true,
+	}
+	m.Entries[id] = entry
+	return *entry, nil
+}
+
+func (m *Manifest) RecordFailure(clip *Clip, filename string, failure error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.Entries[clip.ID]; ok && existing.Status == ManifestComplete {
+		return
+	}
+	entry := newManifestEntry(clip)
+	entry.Path = m.relative(filename)
+	entry.Status = ManifestFailed
+	entry.Error = failure.Error()
+	m.Entries[clip.ID] = entry
+}
+
+// MarkRemoteDeleted flags entries whose clips are no longer in clips, the
+// current clip list, and returns the newly flagged entries
+func (m *Manifest) MarkRemoteDeleted(clips []*Clip) []ManifestEntry {
+	present := make(map[int]bool)
+	for _, clip := range clips {
+		present[clip.ID] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted []ManifestEntry
+	for id, entry := range m.Entries {
+		if entry.Joined {
+			continue
+		}
+		if present[id] {
+			entry.RemoteDeleted = false
+			entry.RemoteDeletedAt = time.Time{}
+			continue
+		}
+		if !entry.RemoteDeleted {
+			entry.RemoteDeleted = true
+			entry.RemoteDeletedAt = time.Now().UTC()
+			if !entry.DeletedBySync {
+				deleted = append(deleted, *entry)
+			}
+		}
+	}
+	return deleted
+}
+
+// MarkDeletedBySync records that the clip was deleted from the server by
+// gonest after it was archived
+func (m *Manifest) MarkDeletedBySync(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Entries[id]
+	if !ok {
+		return
+	}
+	entry.DeletedBySync = true
+	entry.RemoteDeleted = true
+	entry.RemoteDeletedAt = time.Now().UTC()
+}
+
+// DeleteLocal removes the archived file of entry along with its sidecar and
+// thumbnail, and drops it from the manifest
+func (m *Manifest) DeleteLocal(entry ManifestEntry) error {
+	err := removeArchived(m.FullPath(entry))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Entries, entry.ID)
+	return nil
+}
+
+// Prune removes the archived file of entry like DeleteLocal but keeps the
+// entry, marked pruned, so the clip isn't downloaded again
+func (m *Manifest) Prune(entry ManifestEntry) error {
+	if entry.Keep {
+		return fmt.Errorf("clip %d is flagged keep", entry.ID)
+	}
+	err := removeArchived(m.FullPath(entry))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.Entries[entry.ID]; ok {
+		existing.Status = ManifestPruned
+		existing.PrunedAt = time.Now()
+	}
+	return nil
+}
+
+func (m *Manifest) Pruned(id int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Entries[id]
+	return ok && entry.Status == ManifestPruned
+}
+
+// SetKeep flags an entry so the retention policy never deletes it
+func (m *Manifest) SetKeep(id int, keep bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.Entries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.Keep = keep
+	return nil
+}
+
+// removeArchived removes a video along with its sidecar and thumbnail
+func removeArchived(filename string) error {
+	for _, extra := range []string{filename, SidecarFilename(filename), strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"} {
+		err := os.Remove(extra)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+type ImportResult struct {
+	Adopted   []ManifestEntry
+	Moved     []ManifestEntry
+	Missing   []ManifestEntry
+	Unmatched []string
+}
+
+// Import scans the archive directory and adopts mp4 files which are not in
+// the manifest yet. Files are matched to clips by their sidecar, by the
+// server filename in clips, or by hash for files which have been moved.
+func (m *Manifest) Import(clips []*Clip) (*ImportResult, error) {
+	byFilename := make(map[string]*Clip)
+	for _, clip := range clips {
+		byFilename[clip.Filename] = clip
+	}
+
+	m.mu.Lock()
+	known := make(map[string]int)
+	for id, entry := range m.Entries {
+		known[entry.Path] = id
+	}
+	m.mu.Unlock()
+
+	result := &ImportResult{}
+	seen := make(map[string]bool)
+	err := filepath.Walk(m.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".mp4" {
+			return nil
+		}
+
+		rel := m.relative(path)
+		seen[rel] = true
+		if _, ok := known[rel]; ok {
+			return nil
+		}
+
+		sum, err := FileSHA256(path)
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// a file which moved still has the hash of a missing entry
+		for _, entry := range m.Entries {
+			if entry.SHA256 == sum && !FileExists(m.FullPath(*entry)) {
+				entry.Path = rel
+				entry.Status = ManifestComplete
+				result.Moved = append(result.Moved, *entry)
+				return nil
+			}
+		}
+
+		// only files matched against the clip list are known to be clip
+		// downloads, a sidecar is written for exports as well
+		var source ManifestSource
+		clip := sidecarClip(path)
+		if clip == nil {
+			clip = byFilename[filepath.Base(path)]
+			source = ManifestFromClips
+		}
+		if clip == nil {
+			result.Unmatched = append(result.Unmatched, path)
+			return nil
+		}
+		if existing, ok := m.Entries[clip.ID]; ok && existing.Status == ManifestComplete && FileExists(m.FullPath(*existing)) {
+			log.WithFields(log.Fields{
+				"id":       clip.ID,
+				"filename": path,
+				"existing": existing.Path,
+			}).Warn("clip is already in the manifest under another path")
+			return nil
+		}
+
+		entry := newManifestEntry(clip)
+		entry.Path = rel
+		entry.Size = info.Size()
+		entry.SHA256 = sum
+		entry.Status = ManifestComplete
+		entry.DownloadedAt = info.ModTime().UTC()
+		entry.Source = source
+		m.Entries[clip.ID] = entry
+		result.Adopted = append(result.Adopted, *entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range m.Entries {
+		if entry.Status == ManifestComplete && !seen[entry.Path] && !FileExists(m.FullPath(*entry)) {
+			entry.Status = ManifestMissing
+			result.Missing = append(result.Missing, *entry)
+		}
+	}
+	return result, nil
+}
+
+// sidecarClip reads the clip record from the sidecar of filename, if any
+func sidecarClip(filename string) *Clip {
+	data, err := ioutil.ReadFile(SidecarFilename(filename))
+	if err != nil {
+		return nil
+	}
+	var sidecar Sidecar
+	err = json.Unmarshal(data, &sidecar)
+	if err != nil || sidecar.Clip == nil {
+		return nil
+	}
+	var clip Clip
+	err = json.Unmarshal(sidecar.Clip, &clip)
+	if err != nil || clip.ID == 0 {
+		return nil
+	}
+	return &clip
+}
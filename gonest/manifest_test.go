@@ -0,0 +1,215 @@
+package gonest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestManifestRecord(t *testing.T) {
+	directory := t.TempDir()
+	filename := filepath.Join(directory, "camera", "clip.mp4")
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filename, []byte("video"))
+
+	manifest, err := LoadManifest(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clip := &Clip{ID: 7, CameraUUID: "a", Length: 30, StartTimeFloat: 1700000000}
+	err = manifest.Record(clip, filename, ManifestFromClips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manifest.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadManifest(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := loaded.Get(7)
+	if !ok {
+		t.Fatal("recorded clip is missing after loading the manifest")
+	}
+	if entry.Path != filepath.Join("camera", "clip.mp4") {
+		t.Errorf("got path %q, expected it relative to the archive", entry.Path)
+	}
+	if entry.Size != 5 || entry.SHA256 == "" || entry.Source != ManifestFromClips || entry.Status != ManifestComplete {
+		t.Errorf("got %+v", entry)
+	}
+	if !entry.Start.Equal(time.Unix(1700000000, 0)) || entry.End.Sub(entry.Start) != 30*time.Second {
+		t.Errorf("got %s - %s, expected 30 seconds from the clip start", entry.Start, entry.End)
+	}
+	if !loaded.Complete(7) {
+		t.Error("recorded clip is not complete")
+	}
+
+	loaded.RecordFailure(clip, filename, os.ErrNotExist)
+	if entry, _ := loaded.Get(7); entry.Status != ManifestComplete {
+		t.Error("a failure replaced a complete download")
+	}
+
+	err = os.Remove(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Complete(7) {
+		t.Error("clip is complete without its file")
+	}
+}
+
+func TestManifestRecordJoined(t *testing.T) {
+	directory := t.TempDir()
+	manifest, err := LoadManifest(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := TimeRange{Start: time.Unix(1700000000, 0), End: time.Unix(1700003600, 0)}
+
+	var ids []int
+	for _, name := range []string{"first.mp4", "second.mp4", "first.mp4"} {
+		filename := filepath.Join(directory, name)
+		writeTestFile(t, filename, []byte(name))
+		entry, err := manifest.RecordJoined("a", r, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !entry.Joined || entry.Source != ManifestFromExport || !entry.Start.Equal(r.Start) || !entry.End.Equal(r.End) {
+			t.Errorf("got %+v", entry)
+		}
+		ids = append(ids, entry.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{-1, -2, -1}) {
+		t.Errorf("got ids %v, expected -1 and -2 with the first reused", ids)
+	}
+
+	if deleted := manifest.MarkRemoteDeleted(nil); len(deleted) != 0 {
+		t.Errorf("joined files were flagged as deleted in the app: %v", deleted)
+	}
+}
+
+func TestMarkRemoteDeleted(t *testing.T) {
+	manifest := &Manifest{Entries: map[int]*ManifestEntry{
+		1: {ID: 1, Status: ManifestComplete},
+		2: {ID: 2, Status: ManifestComplete},
+		3: {ID: 3, Status: ManifestComplete, RemoteDeleted: true},
+		4: {ID: 4, Status: ManifestComplete},
+	}}
+	manifest.MarkDeletedBySync(4)
+
+	deleted := manifest.MarkRemoteDeleted([]*Clip{{ID: 1}, {ID: 3}})
+	if ids := entryIDs(deleted); !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("got newly deleted %v, expected [2]", ids)
+	}
+	for id, expected := range map[int]bool{1: false, 2: true, 3: false, 4: true} {
+		if entry, _ := manifest.Get(id); entry.RemoteDeleted != expected {
+			t.Errorf("clip %d remote deleted is %t, expected %t", id, entry.RemoteDeleted, expected)
+		}
+	}
+
+	if deleted := manifest.MarkRemoteDeleted([]*Clip{{ID: 1}, {ID: 3}}); len(deleted) != 0 {
+		t.Errorf("entries were flagged twice: %v", entryIDs(deleted))
+	}
+}
+
+func TestManifestImport(t *testing.T) {
+	directory := t.TempDir()
+	write := func(name string, data string) string {
+		filename := filepath.Join(directory, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filename, []byte(data))
+		return filename
+	}
+
+	write("known.mp4", "known")
+	write("byname.mp4", "byname")
+	write("sidecar.mp4", "sidecar")
+	write("sidecar.json", `{"clip":{"id":20,"camera_uuid":"a","length_in_seconds":30}}`)
+	moved := write("moved/renamed.mp4", "moved")
+	write("stray.mp4", "stray")
+	write("notes.txt", "not a video")
+
+	sum, err := FileSHA256(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{Directory: directory, Entries: map[int]*ManifestEntry{
+		1: {ID: 1, Path: "known.mp4", Status: ManifestComplete},
+		2: {ID: 2, Path: "old.mp4", SHA256: sum, Status: ManifestComplete},
+		3: {ID: 3, Path: "gone.mp4", SHA256: "other", Status: ManifestComplete},
+	}}
+
+	result, err := manifest.Import([]*Clip{{ID: 10, Filename: "byname.mp4"}, {ID: 11, Filename: "other.mp4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := entryIDs(result.Adopted); !reflect.DeepEqual(ids, []int{10, 20}) {
+		t.Errorf("got adopted %v, expected [10 20]", ids)
+	}
+	if ids := entryIDs(result.Moved); !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("got moved %v, expected [2]", ids)
+	}
+	if ids := entryIDs(result.Missing); !reflect.DeepEqual(ids, []int{3}) {
+		t.Errorf("got missing %v, expected [3]", ids)
+	}
+	if !reflect.DeepEqual(result.Unmatched, []string{filepath.Join(directory, "stray.mp4")}) {
+		t.Errorf("got unmatched %v", result.Unmatched)
+	}
+
+	tests := []struct {
+		id     int
+		path   string
+		source ManifestSource
+		status ManifestStatus
+	}{
+		{id: 1, path: "known.mp4", status: ManifestComplete},
+		{id: 2, path: filepath.Join("moved", "renamed.mp4"), status: ManifestComplete},
+		{id: 3, path: "gone.mp4", status: ManifestMissing},
+		{id: 10, path: "byname.mp4", source: ManifestFromClips, status: ManifestComplete},
+		{id: 20, path: "sidecar.mp4", status: ManifestComplete},
+	}
+	for _, test := range tests {
+		entry, ok := manifest.Get(test.id)
+		if !ok {
+			t.Errorf("clip %d is not in the manifest", test.id)
+			continue
+		}
+		if entry.Path != test.path || entry.Source != test.source || entry.Status != test.status {
+			t.Errorf("clip %d is %q from %q and %s, expected %q from %q and %s", test.id, entry.Path, entry.Source, entry.Status, test.path, test.source, test.status)
+		}
+	}
+	if _, ok := manifest.Get(11); ok {
+		t.Error("a clip without a file was adopted")
+	}
+}
+
+func writeTestFile(t *testing.T, filename string, data []byte) {
+	t.Helper()
+	err := ioutil.WriteFile(filename, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func entryIDs(entries []ManifestEntry) []int {
+	var ids []int
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	sort.Ints(ids)
+	return ids
+}
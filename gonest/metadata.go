@@ -0,0 +1,185 @@
+package gonest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdamJacobMuller/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sidecar is written next to a downloaded clip so its details survive once
+// the clip is gone from the server
+type Sidecar struct {
+	Clip         json.RawMessage `json:"clip"`
+	DownloadedAt time.Time       `json:"downloaded_at"`
+	SHA256       string          `json:"sha256"`
+	SourceURL    string          `json:"source_url"`
+}
+
+// mp4Epoch is where mvhd creation and modification times count from
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (c *Clip) UnmarshalJSON(data []byte) error {
+	type clip Clip
+	err := json.Unmarshal(data, (*clip)(c))
+	if err != nil {
+		return err
+	}
+	c.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// Raw returns the clip exactly as the server sent it
+func (c Clip) Raw() json.RawMessage {
+	if c.raw != nil {
+		return c.raw
+	}
+	raw, _ := json.Marshal(c)
+	return raw
+}
+
+func SidecarFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".json"
+}
+
+func FileSHA256(filename string) (string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, fh)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WriteSidecar writes the clip record, a hash of filename and where it came
+// from to the sidecar file of filename
+func (c Clip) WriteSidecar(filename string) error {
+	return c.writeSidecar(filename, log.StandardLogger())
+}
+
+func (c Clip) writeSidecar(filename string, logger log.FieldLogger) error {
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		return err
+	}
+
+	sidecar := Sidecar{
+		Clip:         c.Raw(),
+		DownloadedAt: time.Now().UTC(),
+		SHA256:       sum,
+		SourceURL:    c.DownloadURL,
+	}
+	err = golib.SaveFile(SidecarFilename(filename), sidecar)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":       c.ID,
+			"filename": filename,
+			"error":    err,
+		}).Error("failed writing sidecar")
+		return err
+	}
+	return nil
+}
+
+// EmbedMetadata sets the mp4 creation time to the clip start time and stores
+// the title and date as iTunes style metadata, which is what most players
+// and photo libraries read
+func (c Clip) EmbedMetadata(filename string) error {
+	return c.embedMetadata(filename, log.StandardLogger())
+}
+
+func (c Clip) embedMetadata(filename string, logger log.FieldLogger) error {
+	start := c.StartTime()
+	err := rewriteMoov(filename, func(moov *atom) error {
+		mvhd := moov.Child("mvhd")
+		if mvhd == nil {
+			return fmt.Errorf("%w: missing mvhd box", ErrNotMP4)
+		}
+		err := setCreationTime(mvhd, start)
+		if err != nil {
+			return err
+		}
+
+		udta := moov.Child("udta")
+		if udta == nil {
+			udta = &atom{Type: "udta", Children: []*atom{}}
+			moov.Children = append(moov.Children, udta)
+		}
+		udta.Remove("meta")
+		udta.Children = append(udta.Children, metaAtom(map[string]string{
+			"\xa9nam": c.Title,
+			"\xa9day": start.UTC().Format(time.RFC3339),
+			"\xa9cmt": fmt.Sprintf("nest clip %d from camera %s", c.ID, c.CameraUUID),
+		}))
+		return nil
+	})
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"id":       c.ID,
+			"filename": filename,
+			"error":    err,
+		}).Error("failed embedding metadata")
+	}
+	return err
+}
+
+func setCreationTime(mvhd *atom, t time.Time) error {
+	seconds := uint64(t.Sub(mp4Epoch) / time.Second)
+	data := mvhd.Data
+	if len(data) >= 12 && data[0] == 0 {
+		binary.BigEndian.PutUint32(data[4:8], uint32(seconds))
+		binary.BigEndian.PutUint32(data[8:12], uint32(seconds))
+		return nil
+	}
+	if len(data) >= 20 && data[0] == 1 {
+		binary.BigEndian.PutUint64(data[4:12], seconds)
+		binary.BigEndian.PutUint64(data[12:20], seconds)
+		return nil
+	}
+	return fmt.Errorf("%w: invalid mvhd box", ErrNotMP4)
+}
+
+// metaAtom builds udta/meta with an ilst of UTF-8 text items
+func metaAtom(items map[string]string) *atom {
+	var keys []string
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ilst := &atom{Type: "ilst", Children: []*atom{}}
+	for _, key := range keys {
+		value := make([]byte, 8+len(items[key]))
+		binary.BigEndian.PutUint32(value[0:4], 1)
+		copy(value[8:], items[key])
+		ilst.Children = append(ilst.Children, &atom{
+			Type:     key,
+			Children: []*atom{{Type: "data", Data: value}},
+		})
+	}
+
+	hdlr := &atom{Type: "hdlr", Data: append(make([]byte, 8), []byte("mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00")...)}
+
+	var payload bytes.Buffer
+	payload.Write(make([]byte, 4))
+	hdlr.WriteTo(&payload)
+	ilst.WriteTo(&payload)
+	return &atom{Type: "meta", Data: payload.Bytes()}
+}
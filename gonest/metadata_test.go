@@ -0,0 +1,263 @@
+package gonest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// readMeta returns the ilst items of the udta/meta boxes of moov
+func readMeta(t *testing.T, moov *atom) []map[string]string {
+	t.Helper()
+	var metas []map[string]string
+	udta := moov.Child("udta")
+	if udta == nil {
+		return nil
+	}
+	for _, meta := range udta.All("meta") {
+		children, err := parseAtoms(meta.Data[4:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := make(map[string]string)
+		for _, child := range children {
+			if child.Type != "ilst" {
+				continue
+			}
+			// ilst and its items are not containers to parseAtoms
+			ilst, err := parseAtoms(child.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, item := range ilst {
+				data, err := parseAtoms(item.Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(data) != 1 || data[0].Type != "data" || len(data[0].Data) < 8 {
+					t.Fatalf("ilst item %q has no data", item.Type)
+				}
+				items[item.Type] = string(data[0].Data[8:])
+			}
+		}
+		metas = append(metas, items)
+	}
+	return metas
+}
+
+func TestEmbedMetadata(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	clip := Clip{ID: 42, CameraUUID: "abc123", Title: "front door", StartTimeFloat: float64(start.Unix())}
+
+	co64 := videoTrack('v', 10, 20, 30)
+	co64.Co64 = true
+	tests := []struct {
+		name      string
+		mdatFirst bool
+		tracks    []testTrack
+	}{
+		{
+			name:   "mdat after moov",
+			tracks: []testTrack{videoTrack('v', 10, 20, 30), audioTrack('a', 5, 5, 5, 5)},
+		},
+		{
+			name:   "mdat after moov with co64",
+			tracks: []testTrack{co64, audioTrack('a', 5, 5)},
+		},
+		{
+			name:      "mdat first",
+			mdatFirst: true,
+			tracks:    []testTrack{videoTrack('v', 10, 20, 30), audioTrack('a', 5, 5, 5, 5)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "clip.mp4")
+			writeTestMP4(t, filename, test.mdatFirst, test.tracks...)
+			before, err := InspectMP4(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// embedding again replaces the metadata rather than adding to it
+			for i := 0; i < 2; i++ {
+				err = clip.EmbedMetadata(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			after, err := InspectMP4(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Duration != before.Duration {
+				t.Errorf("got duration %s, expected %s", after.Duration, before.Duration)
+			}
+			if after.Size <= before.Size {
+				t.Errorf("got size %d, expected more than %d", after.Size, before.Size)
+			}
+
+			source, err := openConcatSource(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.fh.Close()
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mvhd := source.moov.Child("mvhd").Data
+			seconds := uint32(start.Sub(mp4Epoch) / time.Second)
+			if binary.BigEndian.Uint32(mvhd[4:8]) != seconds || binary.BigEndian.Uint32(mvhd[8:12]) != seconds {
+				t.Errorf("got creation %d modification %d, expected %d", binary.BigEndian.Uint32(mvhd[4:8]), binary.BigEndian.Uint32(mvhd[8:12]), seconds)
+			}
+
+			expected := []map[string]string{{
+				"\xa9nam": "front door",
+				"\xa9day": "2023-11-14T22:13:20Z",
+				"\xa9cmt": "nest clip 42 from camera abc123",
+			}}
+			if metas := readMeta(t, source.moov); !reflect.DeepEqual(metas, expected) {
+				t.Errorf("got metadata %q, expected %q", metas, expected)
+			}
+
+			for i, track := range source.tracks {
+				// the chunks must still point at the samples after the moov grew
+				payload := test.tracks[i].payload()
+				offset := track.chunks[0]
+				if offset+uint64(len(payload)) > uint64(len(data)) || !bytes.Equal(data[offset:offset+uint64(len(payload))], payload) {
+					t.Errorf("track %d chunk at %d does not hold its samples", i, offset)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedMetadataVersion1(t *testing.T) {
+	start := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	mvhd := &atom{Type: "mvhd", Data: testMvhdV1(1000, 5000)}
+	err := setCreationTime(mvhd, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds := uint64(start.Sub(mp4Epoch) / time.Second)
+	if binary.BigEndian.Uint64(mvhd.Data[4:12]) != seconds || binary.BigEndian.Uint64(mvhd.Data[12:20]) != seconds {
+		t.Errorf("got creation %d modification %d, expected %d", binary.BigEndian.Uint64(mvhd.Data[4:12]), binary.BigEndian.Uint64(mvhd.Data[12:20]), seconds)
+	}
+	if binary.BigEndian.Uint64(mvhd.Data[24:32]) != 5000 {
+		t.Error("setting the creation time changed the duration")
+	}
+
+	err = setCreationTime(&atom{Type: "mvhd", Data: []byte{2, 0, 0, 0}}, start)
+	if err == nil {
+		t.Error("set the creation time of an invalid mvhd")
+	}
+}
+
+func TestSettleChunkOffsets(t *testing.T) {
+	// offsets right below the 32 bit limit are pushed over it by the growth
+	// and the upgrade to co64 grows the moov a second time
+	trak := videoTrack('v', 10).trak(1)
+	stco := trak.Path("mdia", "minf", "stbl", "stco")
+	original := []uint64{math.MaxUint32 - 10, math.MaxUint32 - 200}
+	setChunkOffsets(stco, original)
+	moov := &atom{Type: "moov", Children: []*atom{trak}}
+	size := moov.Size() - 100
+
+	settled, err := moov.settleChunkOffsets(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := settled.Path("trak", "mdia", "minf", "stbl", "co64")
+	if chunks == nil {
+		t.Fatal("stco was not upgraded to co64")
+	}
+	offsets, err := chunkOffsets(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta := uint64(settled.Size() - size)
+	if delta != 108 {
+		t.Errorf("moov grew by %d, expected 100 and 8 for the wider offsets", delta)
+	}
+	expected := []uint64{original[0] + delta, original[1] + delta}
+	if !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("got offsets %v, expected %v", offsets, expected)
+	}
+
+	// offsets which still fit stay in stco, settling upgraded the one above
+	stco.Type = "stco"
+	setChunkOffsets(stco, []uint64{1000})
+	moov = &atom{Type: "moov", Children: []*atom{trak}}
+	settled, err = moov.settleChunkOffsets(moov.Size() - 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks = settled.Path("trak", "mdia", "minf", "stbl", "stco")
+	if chunks == nil {
+		t.Fatal("stco was upgraded without needing to be")
+	}
+	offsets, err = chunkOffsets(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(offsets, []uint64{1100}) {
+		t.Errorf("got offsets %v, expected [1100]", offsets)
+	}
+}
+
+func TestWriteSidecar(t *testing.T) {
+	raw := []byte(`{"id":42,"camera_uuid":"abc123","download_url":"https://clips.example/42.mp4","unknown_field":{"kept":true}}`)
+	var clip Clip
+	err := json.Unmarshal(raw, &clip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "clip.mp4")
+	writeTestFile(t, filename, testVideo(t))
+	before := time.Now().UTC()
+	err = clip.WriteSidecar(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(filename), "clip.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sidecar Sidecar
+	err = json.Unmarshal(data, &sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, expected interface{}
+	json.Unmarshal(sidecar.Clip, &got)
+	json.Unmarshal(raw, &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got clip %s, expected the clip as the server sent it %s", sidecar.Clip, raw)
+	}
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.SHA256 != sum {
+		t.Errorf("got sha256 %s, expected %s", sidecar.SHA256, sum)
+	}
+	if sidecar.SourceURL != "https://clips.example/42.mp4" {
+		t.Errorf("got source url %q", sidecar.SourceURL)
+	}
+	if sidecar.DownloadedAt.Before(before.Add(-time.Second)) || sidecar.DownloadedAt.After(time.Now().Add(time.Second)) {
+		t.Errorf("got downloaded at %s, expected about now", sidecar.DownloadedAt)
+	}
+}
@@ -0,0 +1,190 @@
+package gonest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+var (
+	ErrTruncated = errors.New("file is truncated")
+	ErrNotMP4    = errors.New("file is not a valid mp4")
+	ErrDuration  = errors.New("duration does not match clip length")
+)
+
+type mp4Box struct {
+	Type       string
+	Offset     int64
+	Size       int64
+	HeaderSize int64
+}
+
+func (b mp4Box) DataOffset() int64 {
+	return b.Offset + b.HeaderSize
+}
+
+func (b mp4Box) DataSize() int64 {
+	return b.Size - b.HeaderSize
+}
+
+type MP4Info struct {
+	Size     int64
+	Duration time.Duration
+}
+
+// readBoxes reads the boxes between start and end, a box running past end
+// means the file was cut short
+func readBoxes(r io.ReaderAt, start int64, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset := start; offset < end; {
+		if end-offset < 8 {
+			return nil, ErrTruncated
+		}
+		_, err := r.ReadAt(header[:8], offset)
+		if err != nil {
+			return nil, err
+		}
+
+		box := mp4Box{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			Size:       int64(binary.BigEndian.Uint32(header[0:4])),
+			HeaderSize: 8,
+		}
+		switch box.Size {
+		case 0:
+			box.Size = end - offset
+		case 1:
+			if end-offset < 16 {
+				return nil, ErrTruncated
+			}
+			_, err = r.ReadAt(header[8:16], offset+8)
+			if err != nil {
+				return nil, err
+			}
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+			box.HeaderSize = 16
+		}
+
+		if box.Size < box.HeaderSize {
+			return nil, fmt.Errorf("%w: invalid size for %q box at %d", ErrNotMP4, box.Type, offset)
+		}
+		if offset+box.Size > end {
+			return nil, fmt.Errorf("%w: %q box at %d runs %d bytes past the end", ErrTruncated, box.Type, offset, offset+box.Size-end)
+		}
+
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []mp4Box, boxType string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// InspectMP4 checks the top level box structure of an mp4 file and returns
+// its size and duration
+func InspectMP4(filename string) (*MP4Info, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	boxes, err := readBoxes(fh, 0, stat.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(boxes) == 0 || boxes[0].Type != "ftyp" {
+		return nil, fmt.Errorf("%w: missing ftyp box", ErrNotMP4)
+	}
+	moov, ok := findBox(boxes, "moov")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing moov box", ErrNotMP4)
+	}
+	if _, ok := findBox(boxes, "mdat"); !ok {
+		return nil, fmt.Errorf("%w: missing mdat box", ErrNotMP4)
+	}
+
+	children, err := readBoxes(fh, moov.DataOffset(), moov.Offset+moov.Size)
+	if err != nil {
+		return nil, err
+	}
+	mvhd, ok := findBox(children, "mvhd")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing mvhd box", ErrNotMP4)
+	}
+
+	data := make([]byte, mvhd.DataSize())
+	_, err = fh.ReadAt(data, mvhd.DataOffset())
+	if err != nil {
+		return nil, err
+	}
+	timescale, duration, err := parseMvhd(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MP4Info{
+		Size:     stat.Size(),
+		Duration: time.Duration(float64(duration) / float64(timescale) * float64(time.Second)),
+	}, nil
+}
+
+func parseMvhd(data []byte) (uint32, uint64, error) {
+	var timescale uint32
+	var duration uint64
+	if len(data) >= 20 && data[0] == 0 {
+		timescale = binary.BigEndian.Uint32(data[12:16])
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	} else if len(data) >= 32 && data[0] == 1 {
+		timescale = binary.BigEndian.Uint32(data[20:24])
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		return 0, 0, fmt.Errorf("%w: invalid mvhd box", ErrNotMP4)
+	}
+	if timescale == 0 {
+		return 0, 0, fmt.Errorf("%w: mvhd timescale is 0", ErrNotMP4)
+	}
+	return timescale, duration, nil
+}
+
+// VerifyMP4 checks that filename is a complete mp4 and, when length is
+// non-zero, that it plays for roughly length seconds
+func VerifyMP4(filename string, length float64) error {
+	info, err := InspectMP4(filename)
+	if err != nil {
+		return err
+	}
+
+	if length <= 0 {
+		return nil
+	}
+	tolerance := math.Max(5, length*0.1)
+	if math.Abs(info.Duration.Seconds()-length) > tolerance {
+		return fmt.Errorf("%w: duration is %s, expected %.0fs", ErrDuration, info.Duration, length)
+	}
+	return nil
+}
+
+// Verify checks a saved copy of the clip against what the server reported
+func (c Clip) Verify(filename string) error {
+	return VerifyMP4(filename, c.Length)
+}
@@ -0,0 +1,349 @@
+package gonest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testBox builds a raw box with a 32 bit size
+func testBox(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	box := appendUint32(nil, uint32(8+len(data)))
+	box = append(box, boxType...)
+	return append(box, data...)
+}
+
+// testLargeBox builds a raw box with a 64 bit size
+func testLargeBox(boxType string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	box := appendUint32(nil, 1)
+	box = append(box, boxType...)
+	box = appendUint64(box, uint64(16+len(data)))
+	return append(box, data...)
+}
+
+var testFtyp = testBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+
+// testMvhd builds a version 0 mvhd payload, mdhd shares its layout for the
+// fields gonest reads
+func testMvhd(timescale uint32, duration uint32) []byte {
+	data := make([]byte, 100)
+	binary.BigEndian.PutUint32(data[12:16], timescale)
+	binary.BigEndian.PutUint32(data[16:20], duration)
+	return data
+}
+
+func testMvhdV1(timescale uint32, duration uint64) []byte {
+	data := make([]byte, 112)
+	data[0] = 1
+	binary.BigEndian.PutUint32(data[20:24], timescale)
+	binary.BigEndian.PutUint64(data[24:32], duration)
+	return data
+}
+
+// testTrack describes one track of a file built by writeTestMP4, every sample
+// lasts Delta and all of them sit in a single chunk
+type testTrack struct {
+	Handler   string
+	Timescale uint32
+	Delta     uint32
+	Sizes     []uint32
+	// Sync lists the sync samples, nil leaves out the stss box
+	Sync []uint32
+	// Entry is the sample description, defaults to an empty avc1 box
+	Entry []byte
+	Co64  bool
+	// Fill is the byte every sample of the track is made of
+	Fill byte
+}
+
+func (track testTrack) payload() []byte {
+	var payload []byte
+	for _, size := range track.Sizes {
+		payload = append(payload, bytes.Repeat([]byte{track.Fill}, int(size))...)
+	}
+	return payload
+}
+
+func (track testTrack) trak(id uint32) *atom {
+	duration := track.Delta * uint32(len(track.Sizes))
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], id)
+	binary.BigEndian.PutUint32(tkhd[20:24], duration)
+
+	hdlr := append(make([]byte, 8), track.Handler...)
+	hdlr = append(hdlr, make([]byte, 13)...)
+
+	entry := track.Entry
+	if entry == nil {
+		entry = testBox("avc1", make([]byte, 8))
+	}
+	stsd := append(appendUint32(make([]byte, 4), 1), entry...)
+
+	stts := appendUint32(appendUint32(appendUint32(make([]byte, 4), 1), uint32(len(track.Sizes))), track.Delta)
+	stsc := appendUint32(appendUint32(appendUint32(appendUint32(make([]byte, 4), 1), 1), uint32(len(track.Sizes))), 1)
+	stsz := appendUint32(appendUint32(make([]byte, 4), 0), uint32(len(track.Sizes)))
+	for _, size := range track.Sizes {
+		stsz = appendUint32(stsz, size)
+	}
+	chunks := &atom{Type: "stco"}
+	if track.Co64 {
+		chunks.Type = "co64"
+	}
+	setChunkOffsets(chunks, []uint64{0})
+
+	stbl := &atom{Type: "stbl", Children: []*atom{
+		{Type: "stsd", Data: stsd},
+		{Type: "stts", Data: stts},
+		{Type: "stsc", Data: stsc},
+		{Type: "stsz", Data: stsz},
+		chunks,
+	}}
+	if track.Sync != nil {
+		stss := appendUint32(make([]byte, 4), uint32(len(track.Sync)))
+		for _, sample := range track.Sync {
+			stss = appendUint32(stss, sample)
+		}
+		stbl.Children = append(stbl.Children, &atom{Type: "stss", Data: stss})
+	}
+
+	return &atom{Type: "trak", Children: []*atom{
+		{Type: "tkhd", Data: tkhd},
+		{Type: "mdia", Children: []*atom{
+			{Type: "mdhd", Data: testMvhd(track.Timescale, duration)[:24]},
+			{Type: "hdlr", Data: hdlr},
+			{Type: "minf", Children: []*atom{stbl}},
+		}},
+	}}
+}
+
+// writeTestMP4 writes a playable looking mp4 holding tracks, with the mdat
+// in front of the moov when mdatFirst is set. The movie timescale is 1000.
+func writeTestMP4(t *testing.T, filename string, mdatFirst bool, tracks ...testTrack) {
+	t.Helper()
+
+	var mdat []byte
+	var starts []int
+	var duration uint32
+	moov := &atom{Type: "moov", Children: []*atom{{Type: "mvhd"}}}
+	for i, track := range tracks {
+		starts = append(starts, len(mdat))
+		mdat = append(mdat, track.payload()...)
+		moov.Children = append(moov.Children, track.trak(uint32(i+1)))
+		length := track.Delta * uint32(len(track.Sizes)) * 1000 / track.Timescale
+		if length > duration {
+			duration = length
+		}
+	}
+	moov.Children[0].Data = testMvhd(1000, duration)
+
+	base := len(testFtyp) + 8
+	if !mdatFirst {
+		base += int(moov.Size())
+	}
+	for i, trak := range moov.All("trak") {
+		chunks := trak.Path("mdia", "minf", "stbl", "stco")
+		if chunks == nil {
+			chunks = trak.Path("mdia", "minf", "stbl", "co64")
+		}
+		setChunkOffsets(chunks, []uint64{uint64(base + starts[i])})
+	}
+
+	var buf bytes.Buffer
+	buf.Write(testFtyp)
+	if mdatFirst {
+		buf.Write(testBox("mdat", mdat))
+	}
+	_, err := moov.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mdatFirst {
+		buf.Write(testBox("mdat", mdat))
+	}
+	writeTestFile(t, filename, buf.Bytes())
+}
+
+func TestReadBoxes(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		types []string
+		sizes []int64
+		err   error
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "boxes",
+			data:  append(testBox("ftyp", []byte("isom")), testBox("free")...),
+			types: []string{"ftyp", "free"},
+			sizes: []int64{12, 8},
+		},
+		{
+			name:  "large size",
+			data:  append(testBox("ftyp"), testLargeBox("mdat", make([]byte, 4))...),
+			types: []string{"ftyp", "mdat"},
+			sizes: []int64{8, 20},
+		},
+		{
+			name:  "size zero runs to the end",
+			data:  append(testBox("ftyp"), 0, 0, 0, 0, 'm', 'd', 'a', 't', 1, 2, 3),
+			types: []string{"ftyp", "mdat"},
+			sizes: []int64{8, 11},
+		},
+		{
+			name: "short header",
+			data: append(testBox("ftyp"), 0, 0, 0),
+			err:  ErrTruncated,
+		},
+		{
+			name: "short large size",
+			data: []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0},
+			err:  ErrTruncated,
+		},
+		{
+			name: "box runs past the end",
+			data: testBox("mdat", make([]byte, 8))[:12],
+			err:  ErrTruncated,
+		},
+		{
+			name: "size smaller than header",
+			data: []byte{0, 0, 0, 4, 'f', 'r', 'e', 'e'},
+			err:  ErrNotMP4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			boxes, err := readBoxes(bytes.NewReader(test.data), 0, int64(len(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, expected %v", err, test.err)
+			}
+			if len(boxes) != len(test.types) {
+				t.Fatalf("got %d boxes, expected %d", len(boxes), len(test.types))
+			}
+			for i, box := range boxes {
+				if box.Type != test.types[i] || box.Size != test.sizes[i] {
+					t.Errorf("box %d is %q of %d bytes, expected %q of %d bytes", i, box.Type, box.Size, test.types[i], test.sizes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestInspectMP4(t *testing.T) {
+	directory := t.TempDir()
+
+	moov := testBox("moov", testBox("mvhd", testMvhd(1000, 30500)))
+	mdat := testBox("mdat", make([]byte, 64))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		duration time.Duration
+		err      error
+	}{
+		{
+			name:     "moov first",
+			data:     bytes.Join([][]byte{testFtyp, moov, mdat}, nil),
+			duration: 30500 * time.Millisecond,
+		},
+		{
+			name:     "mdat first",
+			data:     bytes.Join([][]byte{testFtyp, mdat, moov}, nil),
+			duration: 30500 * time.Millisecond,
+		},
+		{
+			name:     "version 1 mvhd",
+			data:     bytes.Join([][]byte{testFtyp, testBox("moov", testBox("mvhd", testMvhdV1(90000, 90000*12))), mdat}, nil),
+			duration: 12 * time.Second,
+		},
+		{
+			name: "truncated mdat",
+			data: bytes.Join([][]byte{testFtyp, moov, mdat}, nil)[:len(testFtyp)+len(moov)+40],
+			err:  ErrTruncated,
+		},
+		{
+			name: "missing ftyp",
+			data: bytes.Join([][]byte{moov, mdat}, nil),
+			err:  ErrNotMP4,
+		},
+		{
+			name: "missing moov",
+			data: bytes.Join([][]byte{testFtyp, mdat}, nil),
+			err:  ErrNotMP4,
+		},
+		{
+			name: "missing mdat",
+			data: bytes.Join([][]byte{testFtyp, moov}, nil),
+			err:  ErrNotMP4,
+		},
+		{
+			name: "missing mvhd",
+			data: bytes.Join([][]byte{testFtyp, testBox("moov", testBox("trak")), mdat}, nil),
+			err:  ErrNotMP4,
+		},
+		{
+			name: "zero timescale",
+			data: bytes.Join([][]byte{testFtyp, testBox("moov", testBox("mvhd", testMvhd(0, 1000))), mdat}, nil),
+			err:  ErrNotMP4,
+		},
+		{
+			name: "empty",
+			err:  ErrNotMP4,
+		},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(directory, fmt.Sprintf("%d.mp4", i))
+			writeTestFile(t, filename, test.data)
+
+			info, err := InspectMP4(filename)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, expected %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if info.Size != int64(len(test.data)) {
+				t.Errorf("got size %d, expected %d", info.Size, len(test.data))
+			}
+			if info.Duration != test.duration {
+				t.Errorf("got duration %s, expected %s", info.Duration, test.duration)
+			}
+		})
+	}
+}
+
+func TestVerifyMP4(t *testing.T) {
+	directory := t.TempDir()
+
+	filename := filepath.Join(directory, "clip.mp4")
+	writeTestMP4(t, filename, false, testTrack{Handler: "vide", Timescale: 90000, Delta: 3000, Sizes: make([]uint32, 900)})
+
+	tests := []struct {
+		length float64
+		err    error
+	}{
+		{length: 0},
+		{length: 30},
+		{length: 34},
+		{length: 26},
+		{length: 36, err: ErrDuration},
+		{length: 60, err: ErrDuration},
+		{length: 20, err: ErrDuration},
+	}
+	for _, test := range tests {
+		err := VerifyMP4(filename, test.length)
+		if !errors.Is(err, test.err) {
+			t.Errorf("length %.0f: got error %v, expected %v", test.length, err, test.err)
+		}
+	}
+}
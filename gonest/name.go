@@ -0,0 +1,120 @@
+package gonest
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ClipName holds the fields available to filename templates, Start and End
+// are in the template's location
+type ClipName struct {
+	ID       int
+	UUID     string
+	Camera   string
+	Title    string
+	Start    time.Time
+	End      time.Time
+	Length   float64
+	Filename string
+}
+
+// The default name templates keep the file layout from before name templates
+// could be set
+const (
+	DefaultClipNameTemplate  = "videos/{{.ID}}.mp4"
+	DefaultVideoNameTemplate = "videos/{{.UUID}}-{{.Start.Unix}}-{{.End.Unix}}.mp4"
+)
+
+type NameTemplate struct {
+	Location *time.Location
+	// CameraDirectories puts every file in a directory named after the
+	// camera, next to the file name, unless the template already has one
+	CameraDirectories bool
+	tmpl              *template.Template
+}
+
+var nameTemplateFuncs = template.FuncMap{
+	"clean": cleanPathComponent,
+	"lower": strings.ToLower,
+}
+
+// ParseNameTemplate parses a text/template for clip filenames, for example
+// {{.Camera}}/{{.Start.Format "2006/01/02"}}/{{.Start.Format "150405"}}-{{.ID}}.mp4
+func ParseNameTemplate(text string, location *time.Location) (*NameTemplate, error) {
+	tmpl, err := template.New("name").Funcs(nameTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &NameTemplate{
+		Location: location,
+		tmpl:     tmpl,
+	}, nil
+}
+
+// NewClipName fills in the template fields for clip, camera is the camera
+// name and falls back to the camera uuid when empty
+func NewClipName(clip *Clip, camera string) ClipName {
+	if camera == "" {
+		camera = clip.CameraUUID
+	}
+	start := clip.StartTime()
+	return ClipName{
+		ID:       clip.ID,
+		UUID:     clip.CameraUUID,
+		Camera:   camera,
+		Title:    clip.Title,
+		Start:    start,
+		End:      start.Add(time.Duration(clip.Length * float64(time.Second))),
+		Length:   clip.Length,
+		Filename: clip.Filename,
+	}
+}
+
+func (t *NameTemplate) Execute(name ClipName) (string, error) {
+	name.Start = name.Start.In(t.Location)
+	name.End = name.End.In(t.Location)
+	name.Camera = cleanPathComponent(name.Camera)
+	name.Title = cleanPathComponent(name.Title)
+
+	var b bytes.Buffer
+	err := t.tmpl.Execute(&b, name)
+	if err != nil {
+		return "", err
+	}
+	filename := b.String()
+	if t.CameraDirectories && !hasDirectory(filename, name.Camera) {
+		filename = filepath.Join(filepath.Dir(filename), name.Camera, filepath.Base(filename))
+	}
+	return filename, nil
+}
+
+// hasDirectory reports if one of the directories of filename is dir
+func hasDirectory(filename string, dir string) bool {
+	for _, component := range strings.Split(filepath.ToSlash(filepath.Dir(filename)), "/") {
+		if component == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPathComponent keeps values such as titles from adding directories
+func cleanPathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', 0:
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "." || s == ".." {
+		return "_"
+	}
+	return s
+}
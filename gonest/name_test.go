@@ -0,0 +1,158 @@
+package gonest
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNameTemplate(t *testing.T) {
+	location := time.FixedZone("EST", -5*60*60)
+	start := time.Unix(1700000000, 0)
+	name := ClipName{
+		ID:       42,
+		UUID:     "abc123",
+		Camera:   "Front Door",
+		Title:    "gonest 42",
+		Start:    start,
+		End:      start.Add(time.Hour),
+		Length:   3600,
+		Filename: "server-name.mp4",
+	}
+	with := func(change func(*ClipName)) ClipName {
+		changed := name
+		change(&changed)
+		return changed
+	}
+
+	tests := []struct {
+		name              string
+		template          string
+		clip              ClipName
+		cameraDirectories bool
+		expected          string
+	}{
+		{
+			name:     "default video layout",
+			template: DefaultVideoNameTemplate,
+			clip:     name,
+			expected: fmt.Sprintf("videos/%s-%d-%d.mp4", "abc123", 1700000000, 1700003600),
+		},
+		{
+			name:     "default clip layout",
+			template: DefaultClipNameTemplate,
+			clip:     name,
+			expected: "videos/42.mp4",
+		},
+		{
+			name:     "server filename",
+			template: "{{.Filename}}",
+			clip:     name,
+			expected: "server-name.mp4",
+		},
+		{
+			name:     "times in the template location",
+			template: `{{.Camera}}/{{.Start.Format "2006/01/02"}}/{{.Start.Format "150405"}}-{{.End.Format "1504"}}-{{.ID}}.mp4`,
+			clip:     name,
+			expected: "Front Door/2023/11/14/171320-1813-42.mp4",
+		},
+		{
+			name:     "functions",
+			template: "{{lower .Camera}}/{{clean .Filename}}",
+			clip:     with(func(c *ClipName) { c.Filename = "a/b.mp4" }),
+			expected: "front door/a_b.mp4",
+		},
+		{
+			name:     "camera with separators",
+			template: "{{.Camera}}/{{.ID}}.mp4",
+			clip:     with(func(c *ClipName) { c.Camera = `garage/../back\yard` }),
+			expected: "garage_.._back_yard/42.mp4",
+		},
+		{
+			name:     "camera named dot dot",
+			template: "{{.Camera}}/{{.ID}}.mp4",
+			clip:     with(func(c *ClipName) { c.Camera = ".." }),
+			expected: "_/42.mp4",
+		},
+		{
+			name:     "title with separators",
+			template: "{{.Title}}.mp4",
+			clip:     with(func(c *ClipName) { c.Title = "../../etc/passwd" }),
+			expected: ".._.._etc_passwd.mp4",
+		},
+		{
+			name:              "camera directories",
+			template:          DefaultClipNameTemplate,
+			clip:              name,
+			cameraDirectories: true,
+			expected:          filepath.Join("videos", "Front Door", "42.mp4"),
+		},
+		{
+			name:              "camera directories with the camera in the template",
+			template:          "{{.Camera}}/{{.ID}}.mp4",
+			clip:              name,
+			cameraDirectories: true,
+			expected:          "Front Door/42.mp4",
+		},
+		{
+			name:              "camera directories with separators",
+			template:          "{{.ID}}.mp4",
+			clip:              with(func(c *ClipName) { c.Camera = "a/b" }),
+			cameraDirectories: true,
+			expected:          filepath.Join("a_b", "42.mp4"),
+		},
+	}
+
+	for _, test := range tests {
+		template, err := ParseNameTemplate(test.template, location)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		template.CameraDirectories = test.cameraDirectories
+		filename, err := template.Execute(test.clip)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if filename != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.name, filename, test.expected)
+		}
+	}
+}
+
+func TestNameTemplateErrors(t *testing.T) {
+	_, err := ParseNameTemplate("{{.ID", nil)
+	if err == nil {
+		t.Error("an unterminated action parsed")
+	}
+
+	template, err := ParseNameTemplate("{{.Missing}}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = template.Execute(ClipName{})
+	if err == nil {
+		t.Error("a missing field executed")
+	}
+}
+
+func TestNewClipName(t *testing.T) {
+	clip := &Clip{ID: 7, CameraUUID: "abc123", Title: "title", StartTimeFloat: 1700000000.5, Length: 30, Filename: "clip.mp4"}
+
+	name := NewClipName(clip, "")
+	if name.Camera != "abc123" {
+		t.Errorf("got camera %q, expected the camera uuid without a name", name.Camera)
+	}
+	if !name.Start.Equal(time.Unix(1700000000, 500000000)) || name.End.Sub(name.Start) != 30*time.Second {
+		t.Errorf("got %s - %s, expected 30 seconds from the clip start", name.Start, name.End)
+	}
+	if name.ID != 7 || name.UUID != "abc123" || name.Title != "title" || name.Length != 30 || name.Filename != "clip.mp4" {
+		t.Errorf("got %+v", name)
+	}
+
+	if name := NewClipName(clip, "Front Door"); name.Camera != "Front Door" {
+		t.Errorf("got camera %q, expected the camera name", name.Camera)
+	}
+}
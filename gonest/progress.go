@@ -0,0 +1,125 @@
+package gonest
+
+import (
+	"sync"
+	"time"
+)
+
+type ProgressState string
+
+const (
+	ProgressWaiting     ProgressState = "waiting"
+	ProgressDownloading ProgressState = "downloading"
+	ProgressVerifying   ProgressState = "verifying"
+	ProgressDone        ProgressState = "done"
+	ProgressFailed      ProgressState = "failed"
+)
+
+type Progress struct {
+	ID       int
+	Filename string
+	State    ProgressState
+	// Done includes anything already on disk from a resumed download
+	Done int64
+	// Total is -1 when the server did not send a Content-Length
+	Total int64
+	// Rate is the download rate in bytes per second
+	Rate float64
+	// ETA is 0 when it can not be estimated
+	ETA time.Duration
+	// Attempts is how often the server said the clip is not processed yet
+	Attempts int
+}
+
+// progressWriter counts the bytes written through it and reports them to a
+// progress callback
+type progressWriter struct {
+	mu       sync.Mutex
+	report   func(Progress)
+	interval time.Duration
+	progress Progress
+	started  time.Time
+	offset   int64
+	last     time.Time
+}
+
+func newProgressWriter(opts SaveOptions, id int, filename string) *progressWriter {
+	interval := opts.ProgressInterval
+	if interval == 0 {
+		interval = 500 * time.Millisecond
+	}
+	return &progressWriter{
+		report:   opts.Progress,
+		interval: interval,
+		progress: Progress{
+			ID:       id,
+			Filename: filename,
+			Total:    -1,
+		},
+	}
+}
+
+func (p *progressWriter) waiting(attempts int) {
+	if p == nil || p.report == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.State = ProgressWaiting
+	p.progress.Attempts = attempts
+	p.report(p.progress)
+}
+
+func (p *progressWriter) start(offset int64, total int64) {
+	if p == nil || p.report == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Now()
+	p.offset = offset
+	p.progress.State = ProgressDownloading
+	p.progress.Done = offset
+	p.progress.Total = total
+	p.report(p.progress)
+	p.last = p.started
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	if p.report == nil {
+		return len(b), nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Done += int64(len(b))
+
+	now := time.Now()
+	if now.Sub(p.last) < p.interval {
+		return len(b), nil
+	}
+	p.last = now
+
+	elapsed := now.Sub(p.started).Seconds()
+	if elapsed > 0 {
+		p.progress.Rate = float64(p.progress.Done-p.offset) / elapsed
+	}
+	if p.progress.Rate > 0 && p.progress.Total > 0 {
+		remaining := float64(p.progress.Total - p.progress.Done)
+		p.progress.ETA = time.Duration(remaining / p.progress.Rate * float64(time.Second))
+	}
+	p.report(p.progress)
+	return len(b), nil
+}
+
+func (p *progressWriter) state(state ProgressState) {
+	if p == nil || p.report == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.State = state
+	if state == ProgressDone {
+		p.progress.ETA = 0
+	}
+	p.report(p.progress)
+}
@@ -0,0 +1,209 @@
+package gonest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateWindow applies Rate between Start and End, both offsets from local
+// midnight. Windows where End is before Start wrap around midnight.
+type RateWindow struct {
+	Start time.Duration
+	End   time.Duration
+	Rate  int64
+}
+
+// BandwidthLimiter is a token bucket shared by every reader it wraps, rates
+// are in bytes per second and 0 means unlimited
+type BandwidthLimiter struct {
+	mu       sync.Mutex
+	rate     int64
+	schedule []RateWindow
+	tokens   float64
+	last     time.Time
+}
+
+func NewBandwidthLimiter(rate int64, schedule []RateWindow) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		rate:     rate,
+		schedule: schedule,
+	}
+}
+
+// Rate returns the limit in effect at now, the first matching schedule window
+// wins over the default rate
+func (l *BandwidthLimiter) Rate(now time.Time) int64 {
+	if l == nil {
+		return 0
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	for _, window := range l.schedule {
+		if window.Start <= window.End {
+			if offset >= window.Start && offset < window.End {
+				return window.Rate
+			}
+		} else if offset >= window.Start || offset < window.End {
+			return window.Rate
+		}
+	}
+	return l.rate
+}
+
+// take accounts for n bytes which have been read and returns how long to
+// wait before reading more
+func (l *BandwidthLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := l.Rate(now)
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+	}
+	if l.tokens > float64(rate) {
+		l.tokens = float64(rate)
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+}
+
+// chunk limits single reads so one read never takes more than a fraction of
+// a second worth of bandwidth
+func (l *BandwidthLimiter) chunk(size int) int {
+	rate := l.Rate(time.Now())
+	if rate <= 0 {
+		return size
+	}
+	max := int(rate / 10)
+	if max < 512 {
+		max = 512
+	}
+	if size > max {
+		return max
+	}
+	return size
+}
+
+// Reader wraps r so that reads from it count against the limit
+func (l *BandwidthLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *BandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:r.limiter.chunk(len(p))])
+	if n > 0 {
+		wait := r.limiter.take(n)
+		if wait > 0 {
+			sleepErr := sleepContext(r.ctx, wait)
+			if sleepErr != nil && err == nil {
+				err = sleepErr
+			}
+		}
+	}
+	return n, err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ParseRate parses rates such as 500k or 2M in bytes per second, suffixes
+// are powers of 1024
+func ParseRate(rate string) (int64, error) {
+	s := strings.TrimSpace(rate)
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	switch strings.ToLower(s[len(s)-1:]) {
+	case "k":
+		multiplier = 1 << 10
+	case "m":
+		multiplier = 1 << 20
+	case "g":
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// ParseSchedule parses comma separated windows such as
+// 08:00-23:00=512k,23:00-08:00=0
+func ParseSchedule(s string) ([]RateWindow, error) {
+	var schedule []RateWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		equals := strings.Index(part, "=")
+		dash := strings.Index(part, "-")
+		if equals == -1 || dash == -1 || dash > equals {
+			return nil, fmt.Errorf("invalid schedule window %q, expected HH:MM-HH:MM=rate", part)
+		}
+
+		start, err := parseClock(part[:dash])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(part[dash+1 : equals])
+		if err != nil {
+			return nil, err
+		}
+		rate, err := ParseRate(part[equals+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		schedule = append(schedule, RateWindow{
+			Start: start,
+			End:   end,
+			Rate:  rate,
+		})
+	}
+	return schedule, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
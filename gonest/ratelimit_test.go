@@ -0,0 +1,165 @@
+package gonest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate     string
+		expected int64
+		invalid  bool
+	}{
+		{rate: "", expected: 0},
+		{rate: "0", expected: 0},
+		{rate: "1500", expected: 1500},
+		{rate: "500k", expected: 500 << 10},
+		{rate: "2M", expected: 2 << 20},
+		{rate: "1g", expected: 1 << 30},
+		{rate: "1.5m", expected: 3 << 19},
+		{rate: " 64K ", expected: 64 << 10},
+		{rate: "fast", invalid: true},
+		{rate: "k", invalid: true},
+		{rate: "-1k", invalid: true},
+		{rate: "10kb", invalid: true},
+	}
+	for _, test := range tests {
+		rate, err := ParseRate(test.rate)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%q: parsed as %d", test.rate, rate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.rate, err)
+			continue
+		}
+		if rate != test.expected {
+			t.Errorf("%q: got %d, expected %d", test.rate, rate, test.expected)
+		}
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:30=1k, 23:00-06:00=0,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RateWindow{
+		{Start: 8 * time.Hour, End: 18*time.Hour + 30*time.Minute, Rate: 1 << 10},
+		{Start: 23 * time.Hour, End: 6 * time.Hour, Rate: 0},
+	}
+	if !reflect.DeepEqual(schedule, expected) {
+		t.Errorf("got %+v, expected %+v", schedule, expected)
+	}
+
+	for _, invalid := range []string{"08:00=1k", "08:00-18:00", "08:00-25:00=1k", "8-18=1k", "08:00-18:00=fast", "=1k-08:00"} {
+		_, err := ParseSchedule(invalid)
+		if err == nil {
+			t.Errorf("%q: parsed", invalid)
+		}
+	}
+}
+
+func TestBandwidthLimiterRate(t *testing.T) {
+	// the lunch window overlaps the day window and is never reached
+	schedule, err := ParseSchedule("08:00-18:00=1k,22:00-06:00=2M,12:00-13:00=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewBandwidthLimiter(100, schedule)
+
+	day := func(hour, minute int) time.Time {
+		return time.Date(2023, 11, 14, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		at       time.Time
+		expected int64
+	}{
+		{at: day(7, 59), expected: 100},
+		{at: day(8, 0), expected: 1 << 10},
+		{at: day(12, 30), expected: 1 << 10},
+		{at: day(18, 0), expected: 100},
+		{at: day(22, 0), expected: 2 << 20},
+		{at: day(23, 59), expected: 2 << 20},
+		{at: day(0, 0), expected: 2 << 20},
+		{at: day(5, 59), expected: 2 << 20},
+		{at: day(6, 0), expected: 100},
+	}
+	for _, test := range tests {
+		if rate := limiter.Rate(test.at); rate != test.expected {
+			t.Errorf("%s: got %d, expected %d", test.at.Format("15:04"), rate, test.expected)
+		}
+	}
+
+	var nilLimiter *BandwidthLimiter
+	if nilLimiter.Rate(day(12, 0)) != 0 {
+		t.Error("a nil limiter limits")
+	}
+	reader := bytes.NewReader(nil)
+	if nilLimiter.Reader(context.Background(), reader) != reader {
+		t.Error("a nil limiter wrapped the reader")
+	}
+}
+
+func TestBandwidthLimiterReader(t *testing.T) {
+	// two readers share 40000 bytes per second, 8000 bytes between them take
+	// about 200ms
+	limiter := NewBandwidthLimiter(40000, nil)
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := io.Copy(ioutil.Discard, limiter.Reader(context.Background(), bytes.NewReader(make([]byte, 4000))))
+			if err != nil || n != 4000 {
+				t.Errorf("read %d bytes: %v", n, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("took %s, expected about 200ms", elapsed)
+	}
+
+	// a cancelled read stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter = NewBandwidthLimiter(1000, nil)
+	_, err := io.Copy(ioutil.Discard, limiter.Reader(ctx, bytes.NewReader(make([]byte, 4000))))
+	if err != context.Canceled {
+		t.Errorf("got %v, expected %v", err, context.Canceled)
+	}
+}
+
+func TestOpenRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 4000))
+	}))
+	defer server.Close()
+
+	clip := Clip{nest: &Nest{}, ID: 1, DownloadURL: server.URL + "/1.mp4"}
+	var buf bytes.Buffer
+	started := time.Now()
+	n, err := clip.WriteToWithOptions(&buf, SaveOptions{RateLimit: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4000 {
+		t.Errorf("got %d bytes, expected 4000", n)
+	}
+	if elapsed := time.Since(started); elapsed < 150*time.Millisecond {
+		t.Errorf("took %s, expected the rate limit to hold it to about 200ms", elapsed)
+	}
+}
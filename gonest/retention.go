@@ -0,0 +1,162 @@
+package gonest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RetentionRule limits how much archived video of a camera is kept, zero
+// values don't limit anything
+type RetentionRule struct {
+	// Camera is a camera uuid, the rule without one applies to every camera
+	// that has no rule of its own
+	Camera   string  `json:"camera"`
+	KeepDays int     `json:"keep_days"`
+	MaxGB    float64 `json:"max_gb"`
+	// HourlyAfterDays thins older video down to one clip per hour
+	HourlyAfterDays int `json:"hourly_after_days"`
+}
+
+func (r RetentionRule) String() string {
+	return fmt.Sprintf("keep %d days, max %.1fGB, hourly after %d days", r.KeepDays, r.MaxGB, r.HourlyAfterDays)
+}
+
+// Empty reports if the rule limits nothing
+func (r RetentionRule) Empty() bool {
+	return r.KeepDays <= 0 && r.MaxGB <= 0 && r.HourlyAfterDays <= 0
+}
+
+type RetentionPolicy []RetentionRule
+
+// Empty reports if no rule of the policy limits anything
+func (p RetentionPolicy) Empty() bool {
+	for _, rule := range p {
+		if !rule.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Rule returns the rule for camera, falling back to the default rule
+func (p RetentionPolicy) Rule(camera string) (RetentionRule, bool) {
+	var fallback *RetentionRule
+	for i, rule := range p {
+		if rule.Camera == camera {
+			return rule, true
+		}
+		if rule.Camera == "" {
+			fallback = &p[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return RetentionRule{}, false
+}
+
+type PruneReason string
+
+const (
+	PruneExpired  PruneReason = "expired"
+	PruneThinned  PruneReason = "thinned"
+	PruneOverSize PruneReason = "over size"
+)
+
+type PruneCandidate struct {
+	Entry  ManifestEntry
+	Reason PruneReason
+}
+
+// PlanPrune picks the downloads the policy no longer wants, oldest first.
+// Entries flagged Keep are never picked, though they still count towards
+// MaxGB.
+func (m *Manifest) PlanPrune(policy RetentionPolicy, now time.Time) []PruneCandidate {
+	cameras := make(map[string][]ManifestEntry)
+	for _, entry := range m.List() {
+		if entry.Status != ManifestComplete {
+			continue
+		}
+		cameras[entry.CameraUUID] = append(cameras[entry.CameraUUID], entry)
+	}
+
+	var candidates []PruneCandidate
+	for camera, entries := range cameras {
+		rule, ok := policy.Rule(camera)
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, planCameraPrune(rule, entries, now)...)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Entry.Start.Before(candidates[j].Entry.Start)
+	})
+	return candidates
+}
+
+// planCameraPrune applies rule to the entries of one camera, which are sorted
+// by start time
+func planCameraPrune(rule RetentionRule, entries []ManifestEntry, now time.Time) []PruneCandidate {
+	var candidates []PruneCandidate
+	pruned := make(map[int]bool)
+	prune := func(entry ManifestEntry, reason PruneReason) {
+		if entry.Keep || pruned[entry.ID] {
+			return
+		}
+		pruned[entry.ID] = true
+		candidates = append(candidates, PruneCandidate{Entry: entry, Reason: reason})
+	}
+
+	if rule.KeepDays > 0 {
+		cutoff := now.AddDate(0, 0, -rule.KeepDays)
+		for _, entry := range entries {
+			if entry.End.Before(cutoff) {
+				prune(entry, PruneExpired)
+			}
+		}
+	}
+
+	if rule.HourlyAfterDays > 0 {
+		cutoff := now.AddDate(0, 0, -rule.HourlyAfterDays)
+		kept := make(map[time.Time]bool)
+		// a flagged entry is the one kept for its hour
+		for _, entry := range entries {
+			if entry.Keep {
+				kept[entry.Start.Truncate(time.Hour)] = true
+			}
+		}
+		for _, entry := range entries {
+			if !entry.End.Before(cutoff) || pruned[entry.ID] {
+				continue
+			}
+			hour := entry.Start.Truncate(time.Hour)
+			if !kept[hour] {
+				kept[hour] = true
+				continue
+			}
+			prune(entry, PruneThinned)
+		}
+	}
+
+	if rule.MaxGB > 0 {
+		limit := int64(rule.MaxGB * (1 << 30))
+		var total int64
+		for _, entry := range entries {
+			if !pruned[entry.ID] {
+				total += entry.Size
+			}
+		}
+		for _, entry := range entries {
+			if total <= limit {
+				break
+			}
+			if entry.Keep || pruned[entry.ID] {
+				continue
+			}
+			prune(entry, PruneOverSize)
+			total -= entry.Size
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,171 @@
+package gonest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCandidate struct {
+	ID     int
+	Reason PruneReason
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	hour := now.AddDate(0, 0, -3).Truncate(time.Hour)
+	const mb = 1 << 20
+
+	// entry is a 10 minute download of camera a starting at start
+	entry := func(id int, start time.Time, size int64) *ManifestEntry {
+		return &ManifestEntry{
+			ID:         id,
+			CameraUUID: "a",
+			Start:      start,
+			End:        start.Add(10 * time.Minute),
+			Size:       size,
+			Status:     ManifestComplete,
+		}
+	}
+	daysAgo := func(days int) time.Time {
+		return now.AddDate(0, 0, -days)
+	}
+	with := func(e *ManifestEntry, change func(*ManifestEntry)) *ManifestEntry {
+		change(e)
+		return e
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		entries  []*ManifestEntry
+		expected []testCandidate
+	}{
+		{
+			name:   "cameras without a rule are left alone",
+			policy: RetentionPolicy{{Camera: "b", KeepDays: 1}},
+			entries: []*ManifestEntry{
+				entry(1, daysAgo(10), mb),
+			},
+		},
+		{
+			name:   "keep days",
+			policy: RetentionPolicy{{KeepDays: 7}},
+			entries: []*ManifestEntry{
+				entry(1, daysAgo(10), mb),
+				entry(2, daysAgo(8), mb),
+				entry(3, daysAgo(6), mb),
+				with(entry(4, daysAgo(11), mb), func(e *ManifestEntry) { e.Keep = true }),
+				with(entry(-1, daysAgo(9), mb), func(e *ManifestEntry) {
+					e.Joined = true
+					e.Source = ManifestFromExport
+				}),
+				with(entry(5, daysAgo(12), mb), func(e *ManifestEntry) { e.Status = ManifestPruned }),
+				with(entry(6, daysAgo(12), mb), func(e *ManifestEntry) { e.Status = ManifestFailed }),
+			},
+			expected: []testCandidate{{1, PruneExpired}, {-1, PruneExpired}, {2, PruneExpired}},
+		},
+		{
+			name:   "hourly after days",
+			policy: RetentionPolicy{{HourlyAfterDays: 2}},
+			entries: []*ManifestEntry{
+				entry(1, hour, mb),
+				entry(2, hour.Add(20*time.Minute), mb),
+				entry(3, hour.Add(40*time.Minute), mb),
+				entry(4, hour.Add(70*time.Minute), mb),
+				entry(5, daysAgo(1).Truncate(time.Hour), mb),
+				entry(6, daysAgo(1).Truncate(time.Hour).Add(20*time.Minute), mb),
+			},
+			expected: []testCandidate{{2, PruneThinned}, {3, PruneThinned}},
+		},
+		{
+			name:   "a kept entry is the one kept for its hour",
+			policy: RetentionPolicy{{HourlyAfterDays: 2}},
+			entries: []*ManifestEntry{
+				entry(1, hour, mb),
+				with(entry(2, hour.Add(20*time.Minute), mb), func(e *ManifestEntry) { e.Keep = true }),
+			},
+			expected: []testCandidate{{1, PruneThinned}},
+		},
+		{
+			name:   "max size removes the oldest first",
+			policy: RetentionPolicy{{MaxGB: 1}},
+			entries: []*ManifestEntry{
+				entry(1, daysAgo(4), 400*mb),
+				entry(2, daysAgo(3), 400*mb),
+				entry(3, daysAgo(2), 400*mb),
+				entry(4, daysAgo(1), 400*mb),
+			},
+			expected: []testCandidate{{1, PruneOverSize}, {2, PruneOverSize}},
+		},
+		{
+			name:   "kept entries count towards max size",
+			policy: RetentionPolicy{{MaxGB: 1}},
+			entries: []*ManifestEntry{
+				with(entry(1, daysAgo(4), 400*mb), func(e *ManifestEntry) { e.Keep = true }),
+				entry(2, daysAgo(3), 400*mb),
+				entry(3, daysAgo(2), 400*mb),
+				entry(4, daysAgo(1), 400*mb),
+			},
+			expected: []testCandidate{{2, PruneOverSize}, {3, PruneOverSize}},
+		},
+		{
+			name:   "expired entries no longer count towards max size",
+			policy: RetentionPolicy{{KeepDays: 7, MaxGB: 1}},
+			entries: []*ManifestEntry{
+				entry(1, daysAgo(10), 400*mb),
+				entry(2, daysAgo(3), 400*mb),
+				entry(3, daysAgo(2), 400*mb),
+				entry(4, daysAgo(1), 400*mb),
+			},
+			expected: []testCandidate{{1, PruneExpired}, {2, PruneOverSize}},
+		},
+		{
+			name:   "camera rules override the default",
+			policy: RetentionPolicy{{KeepDays: 30}, {Camera: "a", KeepDays: 7}},
+			entries: []*ManifestEntry{
+				entry(1, daysAgo(10), mb),
+				with(entry(2, daysAgo(10), mb), func(e *ManifestEntry) { e.CameraUUID = "b" }),
+				with(entry(3, daysAgo(40), mb), func(e *ManifestEntry) { e.CameraUUID = "b" }),
+			},
+			expected: []testCandidate{{3, PruneExpired}, {1, PruneExpired}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manifest := &Manifest{Entries: make(map[int]*ManifestEntry)}
+			for _, entry := range test.entries {
+				manifest.Entries[entry.ID] = entry
+			}
+
+			var got []testCandidate
+			for _, candidate := range manifest.PlanPrune(test.policy, now) {
+				got = append(got, testCandidate{candidate.Entry.ID, candidate.Reason})
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %v, expected %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyEmpty(t *testing.T) {
+	tests := []struct {
+		policy RetentionPolicy
+		empty  bool
+	}{
+		{policy: nil, empty: true},
+		{policy: RetentionPolicy{{}}, empty: true},
+		{policy: RetentionPolicy{{Camera: "a"}}, empty: true},
+		{policy: RetentionPolicy{{KeepDays: -1, MaxGB: -1}}, empty: true},
+		{policy: RetentionPolicy{{KeepDays: 1}}, empty: false},
+		{policy: RetentionPolicy{{MaxGB: 0.5}}, empty: false},
+		{policy: RetentionPolicy{{HourlyAfterDays: 1}}, empty: false},
+		{policy: RetentionPolicy{{}, {Camera: "a", KeepDays: 1}}, empty: false},
+	}
+	for _, test := range tests {
+		if test.policy.Empty() != test.empty {
+			t.Errorf("%v: got empty %t, expected %t", test.policy, test.policy.Empty(), test.empty)
+		}
+	}
+}
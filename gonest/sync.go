@@ -0,0 +1,91 @@
+package gonest
+
+import (
+	"fmt"
+	"time"
+)
+
+type SyncOptions struct {
+	// DeleteLocal removes local copies of clips from the clip list which were
+	// deleted in the app
+	DeleteLocal bool
+	// DeleteRemoteAfter deletes clips from the server once they are archived,
+	// verified and generated longer ago than this. 0 never deletes.
+	DeleteRemoteAfter time.Duration
+}
+
+// SyncPlan is everything a sync would do, computed up front so it can be
+// shown before anything happens
+type SyncPlan struct {
+	Download     []*Clip
+	DeleteLocal  []ManifestEntry
+	DeleteRemote []*Clip
+	// Unverified are old enough to be deleted from the server but their local
+	// copy did not verify, so they are kept
+	Unverified []*Clip
+}
+
+// PlanSync compares clips, the current clip list, with the manifest
+func PlanSync(clips []*Clip, manifest *Manifest, opts SyncOptions) *SyncPlan {
+	plan := &SyncPlan{}
+
+	present := make(map[int]bool)
+	for _, clip := range clips {
+		present[clip.ID] = true
+
+		if manifest.Pruned(clip.ID) {
+			continue
+		}
+		if !manifest.Complete(clip.ID) {
+			plan.Download = append(plan.Download, clip)
+			continue
+		}
+
+		if opts.DeleteRemoteAfter > 0 && time.Since(clip.GeneratedTime()) > opts.DeleteRemoteAfter {
+			entry, _ := manifest.Get(clip.ID)
+			if manifest.VerifyEntry(entry, clip) == nil {
+				plan.DeleteRemote = append(plan.DeleteRemote, clip)
+			} else {
+				plan.Unverified = append(plan.Unverified, clip)
+			}
+		}
+	}
+
+	if opts.DeleteLocal {
+		// only clip list downloads mirror the app, exports are never in the
+		// clip list and entries of unknown origin are left alone
+		for _, entry := range manifest.List() {
+			if present[entry.ID] || entry.DeletedBySync || entry.Status != ManifestComplete || entry.Source != ManifestFromClips {
+				continue
+			}
+			plan.DeleteLocal = append(plan.DeleteLocal, entry)
+		}
+	}
+
+	return plan
+}
+
+func (p *SyncPlan) Empty() bool {
+	return len(p.Download) == 0 && len(p.DeleteLocal) == 0 && len(p.DeleteRemote) == 0
+}
+
+// VerifyEntry checks the archived copy of clip is a complete mp4 and still
+// has the hash recorded when it was downloaded
+func (m *Manifest) VerifyEntry(entry ManifestEntry, clip *Clip) error {
+	filename := m.FullPath(entry)
+	err := clip.Verify(filename)
+	if err != nil {
+		return err
+	}
+	if entry.SHA256 == "" {
+		return nil
+	}
+	sum, err := FileSHA256(filename)
+	if err != nil {
+		return err
+	}
+	if sum != entry.SHA256 {
+		return fmt.Errorf("%s has changed since it was downloaded", filename)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package gonest
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testEntry is a manifest entry for PlanSync tests, File is "mp4" for a
+// valid 30 second video, "garbage" for a broken one and empty for none
+type testEntry struct {
+	ID            int
+	Source        ManifestSource
+	Status        ManifestStatus
+	Joined        bool
+	DeletedBySync bool
+	File          string
+	SHA256        string
+}
+
+func testManifest(t *testing.T, entries []testEntry) *Manifest {
+	t.Helper()
+	manifest := &Manifest{
+		Directory: t.TempDir(),
+		Entries:   make(map[int]*ManifestEntry),
+	}
+	for _, entry := range entries {
+		status := entry.Status
+		if status == "" {
+			status = ManifestComplete
+		}
+		path := fmt.Sprintf("%d.mp4", entry.ID)
+		filename := filepath.Join(manifest.Directory, path)
+		switch entry.File {
+		case "mp4":
+			writeTestMP4(t, filename, false, testTrack{Handler: "vide", Timescale: 1000, Delta: 1000, Sizes: make([]uint32, 30)})
+		case "garbage":
+			writeTestFile(t, filename, []byte("not a video"))
+		}
+		manifest.Entries[entry.ID] = &ManifestEntry{
+			ID:            entry.ID,
+			Path:          path,
+			Status:        status,
+			Source:        entry.Source,
+			Joined:        entry.Joined,
+			DeletedBySync: entry.DeletedBySync,
+			SHA256:        entry.SHA256,
+		}
+	}
+	return manifest
+}
+
+func testClip(id int, age time.Duration) *Clip {
+	return &Clip{
+		ID:                    id,
+		Length:                30,
+		GeneratedtedTimeFloat: float64(time.Now().Add(-age).Unix()),
+	}
+}
+
+func clipIDs(clips []*Clip) []int {
+	var ids []int
+	for _, clip := range clips {
+		ids = append(ids, clip.ID)
+	}
+	return ids
+}
+
+func TestPlanSync(t *testing.T) {
+	tests := []struct {
+		name         string
+		clips        []*Clip
+		entries      []testEntry
+		opts         SyncOptions
+		download     []int
+		deleteLocal  []int
+		deleteRemote []int
+		unverified   []int
+	}{
+		{
+			name:     "new clips are downloaded",
+			clips:    []*Clip{testClip(1, time.Hour), testClip(2, time.Hour)},
+			download: []int{1, 2},
+		},
+		{
+			name:  "complete clips are not downloaded again",
+			clips: []*Clip{testClip(1, time.Hour)},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips, File: "mp4"},
+			},
+		},
+		{
+			name:  "missing and failed downloads are retried",
+			clips: []*Clip{testClip(1, time.Hour), testClip(2, time.Hour)},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips},
+				{ID: 2, Source: ManifestFromClips, Status: ManifestFailed},
+			},
+			download: []int{1, 2},
+		},
+		{
+			name:  "pruned clips are not downloaded again",
+			clips: []*Clip{testClip(1, time.Hour)},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips, Status: ManifestPruned},
+			},
+		},
+		{
+			name:  "only clip list downloads are deleted locally",
+			clips: []*Clip{testClip(1, time.Hour)},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips, File: "mp4"},
+				{ID: 2, Source: ManifestFromClips, File: "mp4"},
+				{ID: 3, Source: ManifestFromExport, File: "mp4"},
+				{ID: -1, Source: ManifestFromExport, Joined: true, File: "mp4"},
+				{ID: 4, File: "mp4"},
+				{ID: 5, Source: ManifestFromClips, DeletedBySync: true, File: "mp4"},
+				{ID: 6, Source: ManifestFromClips, Status: ManifestFailed},
+				{ID: 7, Source: ManifestFromClips, Status: ManifestPruned},
+			},
+			opts:        SyncOptions{DeleteLocal: true},
+			deleteLocal: []int{2},
+		},
+		{
+			name:  "nothing is deleted locally unless asked",
+			clips: []*Clip{testClip(1, time.Hour)},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips, File: "mp4"},
+				{ID: 2, Source: ManifestFromClips, File: "mp4"},
+			},
+		},
+		{
+			name: "old verified clips are deleted remotely",
+			clips: []*Clip{
+				testClip(1, 48*time.Hour),
+				testClip(2, time.Hour),
+				testClip(3, 48*time.Hour),
+				testClip(4, 48*time.Hour),
+				testClip(5, 48*time.Hour),
+			},
+			entries: []testEntry{
+				{ID: 1, Source: ManifestFromClips, File: "mp4"},
+				{ID: 2, Source: ManifestFromClips, File: "mp4"},
+				{ID: 3, Source: ManifestFromClips, File: "garbage"},
+				{ID: 4, Source: ManifestFromClips, File: "mp4", SHA256: "changed"},
+			},
+			opts:         SyncOptions{DeleteRemoteAfter: 24 * time.Hour},
+			download:     []int{5},
+			deleteRemote: []int{1},
+			unverified:   []int{3, 4},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := PlanSync(test.clips, testManifest(t, test.entries), test.opts)
+			if got := clipIDs(plan.Download); !reflect.DeepEqual(got, test.download) {
+				t.Errorf("got download %v, expected %v", got, test.download)
+			}
+			if got := entryIDs(plan.DeleteLocal); !reflect.DeepEqual(got, test.deleteLocal) {
+				t.Errorf("got delete local %v, expected %v", got, test.deleteLocal)
+			}
+			if got := clipIDs(plan.DeleteRemote); !reflect.DeepEqual(got, test.deleteRemote) {
+				t.Errorf("got delete remote %v, expected %v", got, test.deleteRemote)
+			}
+			if got := clipIDs(plan.Unverified); !reflect.DeepEqual(got, test.unverified) {
+				t.Errorf("got unverified %v, expected %v", got, test.unverified)
+			}
+			empty := test.download == nil && test.deleteLocal == nil && test.deleteRemote == nil
+			if plan.Empty() != empty {
+				t.Errorf("got empty %t, expected %t", plan.Empty(), empty)
+			}
+		})
+	}
+}
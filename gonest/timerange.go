@@ -0,0 +1,156 @@
+package gonest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxClipLength is the longest clip gonest asks the server for in one request.
+// The server does not report its limit, so this is an assumed value which
+// can be changed before requesting clips. Longer ranges are split into
+// several clips rather than sent.
+var MaxClipLength = time.Hour
+
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (r TimeRange) Length() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+func (r TimeRange) String() string {
+	return fmt.Sprintf("%s - %s", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339))
+}
+
+var localTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var relativeTime = regexp.MustCompile(`^([+-])(\d+(?:\.\d+)?)([smhdw])$`)
+
+// ParseTime understands unix timestamps, RFC3339, local dates and times in
+// location, now, today, yesterday and offsets from now such as -24h or -7d
+func ParseTime(s string, location *time.Location, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if location == nil {
+		location = time.Local
+	}
+
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, location); err == nil {
+			return t, nil
+		}
+	}
+
+	midnight := startOfDay(now.In(location))
+	switch strings.ToLower(s) {
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	case "tomorrow":
+		return midnight.AddDate(0, 0, 1), nil
+	}
+
+	match := relativeTime.FindStringSubmatch(strings.ToLower(s))
+	if match != nil {
+		value, _ := strconv.ParseFloat(match[2], 64)
+		unit := map[string]time.Duration{
+			"s": time.Second,
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour,
+		}[match[3]]
+		offset := time.Duration(value * float64(unit))
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return now.Add(offset), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse time %q", s)
+}
+
+// DayRange returns the local day in location as a range, which is not always
+// 24 hours long
+func DayRange(day string, location *time.Location, now time.Time) (TimeRange, error) {
+	if location == nil {
+		location = time.Local
+	}
+	start, err := time.ParseInLocation("2006-01-02", day, location)
+	if err != nil {
+		start, err = ParseTime(day, location, now)
+		if err != nil {
+			return TimeRange{}, err
+		}
+		start = startOfDay(start.In(location))
+	}
+	return TimeRange{
+		Start: start,
+		End:   start.AddDate(0, 0, 1),
+	}, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// WholeSeconds widens r to whole seconds, clips are requested with a start
+// and length in seconds
+func (r TimeRange) WholeSeconds() TimeRange {
+	end := r.End.Truncate(time.Second)
+	if end.Before(r.End) {
+		end = end.Add(time.Second)
+	}
+	return TimeRange{Start: r.Start.Truncate(time.Second), End: end}
+}
+
+// SplitRange widens r to whole seconds and cuts it into segments of at most
+// segment, which is itself capped at MaxClipLength. The last segment may be
+// shorter but is never empty.
+func SplitRange(r TimeRange, segment time.Duration) ([]TimeRange, error) {
+	if !r.End.After(r.Start) {
+		return nil, fmt.Errorf("end %s is not after start %s", r.End.Format(time.RFC3339), r.Start.Format(time.RFC3339))
+	}
+	r = r.WholeSeconds()
+	if segment < time.Second {
+		return nil, fmt.Errorf("segment length %s is shorter than a second", segment)
+	}
+	if segment > MaxClipLength {
+		segment = MaxClipLength
+	}
+	segment = segment.Truncate(time.Second)
+
+	var ranges []TimeRange
+	for start := r.Start; start.Before(r.End); start = start.Add(segment) {
+		end := start.Add(segment)
+		if end.After(r.End) {
+			end = r.End
+		}
+		if end.Sub(start) < time.Second {
+			continue
+		}
+		ranges = append(ranges, TimeRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,223 @@
+package gonest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	location := time.FixedZone("EST", -5*60*60)
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, location)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+		err      bool
+	}{
+		{input: "1700000000", expected: time.Unix(1700000000, 0)},
+		{input: "2024-03-01T12:00:00Z", expected: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{input: "2024-03-01T12:00:00+01:00", expected: time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{input: "2024-03-01 12:00:05", expected: time.Date(2024, 3, 1, 12, 0, 5, 0, location)},
+		{input: "2024-03-01 12:00", expected: time.Date(2024, 3, 1, 12, 0, 0, 0, location)},
+		{input: "2024-03-01T12:00:05", expected: time.Date(2024, 3, 1, 12, 0, 5, 0, location)},
+		{input: "2024-03-01T12:00", expected: time.Date(2024, 3, 1, 12, 0, 0, 0, location)},
+		{input: "2024-03-01", expected: time.Date(2024, 3, 1, 0, 0, 0, 0, location)},
+		{input: "now", expected: now},
+		{input: " today ", expected: time.Date(2024, 3, 10, 0, 0, 0, 0, location)},
+		{input: "Yesterday", expected: time.Date(2024, 3, 9, 0, 0, 0, 0, location)},
+		{input: "tomorrow", expected: time.Date(2024, 3, 11, 0, 0, 0, 0, location)},
+		{input: "-10s", expected: now.Add(-10 * time.Second)},
+		{input: "-30m", expected: now.Add(-30 * time.Minute)},
+		{input: "-24h", expected: now.Add(-24 * time.Hour)},
+		{input: "+1.5h", expected: now.Add(90 * time.Minute)},
+		{input: "-7D", expected: now.Add(-7 * 24 * time.Hour)},
+		{input: "-1w", expected: now.Add(-7 * 24 * time.Hour)},
+		{input: "24h", err: true},
+		{input: "-1y", err: true},
+		{input: "2024-13-01", err: true},
+		{input: "bogus", err: true},
+		{input: "", err: true},
+	}
+	for _, test := range tests {
+		parsed, err := ParseTime(test.input, location, now)
+		if (err != nil) != test.err {
+			t.Errorf("%q: got error %v, expected error %t", test.input, err, test.err)
+			continue
+		}
+		if !parsed.Equal(test.expected) {
+			t.Errorf("%q: got %s, expected %s", test.input, parsed, test.expected)
+		}
+	}
+}
+
+func TestDayRange(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %s", err)
+	}
+	now := time.Date(2024, 3, 12, 9, 0, 0, 0, location)
+
+	tests := []struct {
+		day    string
+		start  time.Time
+		length time.Duration
+	}{
+		{day: "2024-03-09", start: time.Date(2024, 3, 9, 0, 0, 0, 0, location), length: 24 * time.Hour},
+		{day: "2024-03-10", start: time.Date(2024, 3, 10, 0, 0, 0, 0, location), length: 23 * time.Hour},
+		{day: "2024-11-03", start: time.Date(2024, 11, 3, 0, 0, 0, 0, location), length: 25 * time.Hour},
+		{day: "yesterday", start: time.Date(2024, 3, 11, 0, 0, 0, 0, location), length: 24 * time.Hour},
+		{day: "-2d", start: time.Date(2024, 3, 10, 0, 0, 0, 0, location), length: 23 * time.Hour},
+	}
+	for _, test := range tests {
+		r, err := DayRange(test.day, location, now)
+		if err != nil {
+			t.Errorf("%q: %s", test.day, err)
+			continue
+		}
+		if !r.Start.Equal(test.start) || r.Length() != test.length {
+			t.Errorf("%q: got %s lasting %s, expected to start at %s and last %s", test.day, r, r.Length(), test.start, test.length)
+		}
+	}
+}
+
+func TestWholeSeconds(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return base.Add(d)
+	}
+
+	tests := []struct {
+		r        TimeRange
+		expected TimeRange
+	}{
+		{
+			r:        TimeRange{Start: at(0), End: at(10 * time.Second)},
+			expected: TimeRange{Start: at(0), End: at(10 * time.Second)},
+		},
+		{
+			r:        TimeRange{Start: at(400 * time.Millisecond), End: at(10200 * time.Millisecond)},
+			expected: TimeRange{Start: at(0), End: at(11 * time.Second)},
+		},
+		{
+			r:        TimeRange{Start: at(999 * time.Millisecond), End: at(time.Second + time.Nanosecond)},
+			expected: TimeRange{Start: at(0), End: at(2 * time.Second)},
+		},
+	}
+	for _, test := range tests {
+		got := test.r.WholeSeconds()
+		if !got.Start.Equal(test.expected.Start) || !got.End.Equal(test.expected.End) {
+			t.Errorf("%s: got %s, expected %s", test.r, got, test.expected)
+		}
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return base.Add(d)
+	}
+	span := func(start, end time.Duration) TimeRange {
+		return TimeRange{Start: at(start), End: at(end)}
+	}
+
+	tests := []struct {
+		name     string
+		r        TimeRange
+		segment  time.Duration
+		expected []TimeRange
+		err      bool
+	}{
+		{
+			name:     "exact",
+			r:        span(0, 3*time.Hour),
+			segment:  time.Hour,
+			expected: []TimeRange{span(0, time.Hour), span(time.Hour, 2*time.Hour), span(2*time.Hour, 3*time.Hour)},
+		},
+		{
+			name:     "shorter last segment",
+			r:        span(0, 150*time.Minute),
+			segment:  time.Hour,
+			expected: []TimeRange{span(0, time.Hour), span(time.Hour, 2*time.Hour), span(2*time.Hour, 150*time.Minute)},
+		},
+		{
+			name:     "shorter than a segment",
+			r:        span(0, 10*time.Minute),
+			segment:  time.Hour,
+			expected: []TimeRange{span(0, 10*time.Minute)},
+		},
+		{
+			name:     "segment capped at the clip limit",
+			r:        span(0, 2*MaxClipLength),
+			segment:  3 * MaxClipLength,
+			expected: []TimeRange{span(0, MaxClipLength), span(MaxClipLength, 2*MaxClipLength)},
+		},
+		{
+			name:     "fractional range widened to whole seconds",
+			r:        span(400*time.Millisecond, 10200*time.Millisecond),
+			segment:  5 * time.Second,
+			expected: []TimeRange{span(0, 5*time.Second), span(5*time.Second, 10*time.Second), span(10*time.Second, 11*time.Second)},
+		},
+		{
+			name:     "fractional segment truncated to whole seconds",
+			r:        span(0, 5*time.Second),
+			segment:  2500 * time.Millisecond,
+			expected: []TimeRange{span(0, 2*time.Second), span(2*time.Second, 4*time.Second), span(4*time.Second, 5*time.Second)},
+		},
+		{
+			name:     "range shorter than a second",
+			r:        span(200*time.Millisecond, 700*time.Millisecond),
+			segment:  time.Minute,
+			expected: []TimeRange{span(0, time.Second)},
+		},
+		{
+			name:    "end before start",
+			r:       span(time.Hour, 0),
+			segment: time.Hour,
+			err:     true,
+		},
+		{
+			name:    "empty range",
+			r:       span(time.Hour, time.Hour),
+			segment: time.Hour,
+			err:     true,
+		},
+		{
+			name:    "segment shorter than a second",
+			r:       span(0, time.Hour),
+			segment: 500 * time.Millisecond,
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ranges, err := SplitRange(test.r, test.segment)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, expected error %t", err, test.err)
+			}
+			if len(ranges) != len(test.expected) {
+				t.Fatalf("got %v, expected %v", ranges, test.expected)
+			}
+			for i := range ranges {
+				if !ranges[i].Start.Equal(test.expected[i].Start) || !ranges[i].End.Equal(test.expected[i].End) {
+					t.Errorf("segment %d is %s, expected %s", i, ranges[i], test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSplitRangeMaxClipLength(t *testing.T) {
+	defer func(length time.Duration) {
+		MaxClipLength = length
+	}(MaxClipLength)
+	MaxClipLength = 20 * time.Minute
+
+	start := time.Unix(1700000000, 0)
+	ranges, err := SplitRange(TimeRange{Start: start, End: start.Add(time.Hour)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 3 || ranges[0].End.Sub(ranges[0].Start) != 20*time.Minute {
+		t.Errorf("got %v, expected three 20 minute segments", ranges)
+	}
+}
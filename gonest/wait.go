@@ -0,0 +1,279 @@
+package gonest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrClipFailed  = errors.New("clip processing failed on server")
+	ErrWaitTimeout = errors.New("clip not ready before timeout")
+)
+
+type WaitReadyOptions struct {
+	// Timeout defaults to 300 seconds, matching what Save is willing to wait
+	Timeout time.Duration
+	// Interval between polls of the clip list, defaults to 5 seconds
+	Interval time.Duration
+	// Progress, if set, is called for every clip on every poll
+	Progress func(WaitStatus)
+}
+
+type WaitStatus struct {
+	ID        int
+	Attempts  int
+	Elapsed   time.Duration
+	Generated bool
+	Failed    bool
+}
+
+func (o WaitReadyOptions) withDefaults() WaitReadyOptions {
+	if o.Timeout == 0 {
+		o.Timeout = 300 * time.Second
+	}
+	if o.Interval == 0 {
+		o.Interval = 5 * time.Second
+	}
+	return o
+}
+
+// WaitReady polls the API until the server has finished generating the clip
+// and returns the refreshed clip, which has a usable DownloadURL
+func (c Clip) WaitReady(ctx context.Context, opts WaitReadyOptions) (*Clip, error) {
+	clip := c
+	errs := c.nest.WaitClipsReady(ctx, []*Clip{&clip}, opts)
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	return &clip, nil
+}
+
+// WaitClipsReady waits on many clips at once using a single clip list request
+// per poll. Clips are refreshed in place and the returned errors line up with
+// the clips passed in.
+func (n *Nest) WaitClipsReady(ctx context.Context, clips []*Clip, opts WaitReadyOptions) []error {
+	opts = opts.withDefaults()
+
+	errs := make([]error, len(clips))
+	done := make([]bool, len(clips))
+	pending := len(clips)
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	started := time.Now()
+	attempts := 0
+	for {
+		attempts += 1
+		current, err := n.pollClips(clips, done)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":    err,
+				"attempts": attempts,
+			}).Info("hopefully temporary error polling clips")
+		} else {
+			byID := clipsByID(current)
+			for i, clip := range clips {
+				if done[i] {
+					continue
+				}
+				finished, err := refreshWaiting(clip, byID, opts, attempts, started)
+				if !finished {
+					continue
+				}
+				errs[i] = err
+				done[i] = true
+				pending -= 1
+			}
+		}
+
+		if pending == 0 {
+			return errs
+		}
+
+		select {
+		case <-ctx.Done():
+			err := ctx.Err()
+			if err == context.DeadlineExceeded {
+				err = ErrWaitTimeout
+			}
+			for i := range clips {
+				if !done[i] {
+					errs[i] = err
+				}
+			}
+			return errs
+		case <-time.After(opts.Interval):
+		}
+	}
+}
+
+func clipsByID(clips []*Clip) map[int]*Clip {
+	byID := make(map[int]*Clip)
+	for _, clip := range clips {
+		byID[clip.ID] = clip
+	}
+	return byID
+}
+
+// refreshWaiting updates clip from a poll, reports progress and returns
+// whether waiting on it is over
+func refreshWaiting(clip *Clip, current map[int]*Clip, opts WaitReadyOptions, attempts int, started time.Time) (bool, error) {
+	fresh, ok := current[clip.ID]
+	if ok {
+		*clip = *fresh
+	}
+
+	if opts.Progress != nil {
+		opts.Progress(WaitStatus{
+			ID:        clip.ID,
+			Attempts:  attempts,
+			Elapsed:   time.Since(started),
+			Generated: clip.IsGenerated,
+			Failed:    clip.IsError,
+		})
+	}
+
+	if clip.IsError {
+		return true, ErrClipFailed
+	}
+	return clip.IsGenerated, nil
+}
+
+type waitRequest struct {
+	clip     *Clip
+	started  time.Time
+	attempts int
+	reply    chan error
+}
+
+// clipWaiter is WaitClipsReady for clips which start waiting at different
+// times, such as the clips an Exporter keeps in flight. Every clip waiting at
+// the time shares one poll per interval.
+type clipWaiter struct {
+	ctx  context.Context
+	nest *Nest
+	opts WaitReadyOptions
+	add  chan *waitRequest
+}
+
+// newClipWaiter starts a waiter which runs until ctx is done
+func (n *Nest) newClipWaiter(ctx context.Context, opts WaitReadyOptions) *clipWaiter {
+	w := &clipWaiter{
+		ctx:  ctx,
+		nest: n,
+		opts: opts.withDefaults(),
+		add:  make(chan *waitRequest),
+	}
+	go w.run()
+	return w
+}
+
+// Wait blocks until clip is ready or failed, clip is refreshed in place
+func (w *clipWaiter) Wait(clip *Clip) error {
+	request := &waitRequest{
+		clip:    clip,
+		started: time.Now(),
+		reply:   make(chan error, 1),
+	}
+	select {
+	case w.add <- request:
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+	return <-request.reply
+}
+
+func (w *clipWaiter) run() {
+	var waiting []*waitRequest
+	for {
+		if len(waiting) == 0 {
+			select {
+			case request := <-w.add:
+				waiting = append(waiting, request)
+			case <-w.ctx.Done():
+				return
+			}
+		}
+
+		waiting = w.poll(waiting)
+		if len(waiting) == 0 {
+			continue
+		}
+
+		next := time.After(w.opts.Interval)
+	sleep:
+		for {
+			select {
+			case request := <-w.add:
+				waiting = append(waiting, request)
+			case <-next:
+				break sleep
+			case <-w.ctx.Done():
+				for _, request := range waiting {
+					request.reply <- w.ctx.Err()
+				}
+				return
+			}
+		}
+	}
+}
+
+// poll refreshes every waiting clip with one request and returns the clips
+// which are still not ready
+func (w *clipWaiter) poll(waiting []*waitRequest) []*waitRequest {
+	clips := make([]*Clip, len(waiting))
+	for i, request := range waiting {
+		clips[i] = request.clip
+	}
+	current, err := w.nest.pollClips(clips, make([]bool, len(clips)))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":   err,
+			"waiting": len(waiting),
+		}).Info("hopefully temporary error polling clips")
+	}
+	byID := clipsByID(current)
+
+	var still []*waitRequest
+	for _, request := range waiting {
+		request.attempts += 1
+		if err == nil {
+			finished, err := refreshWaiting(request.clip, byID, w.opts, request.attempts, request.started)
+			if finished {
+				request.reply <- err
+				continue
+			}
+		}
+		if time.Since(request.started) >= w.opts.Timeout {
+			request.reply <- ErrWaitTimeout
+			continue
+		}
+		still = append(still, request)
+	}
+	return still
+}
+
+// pollClips fetches the clips still being waited on, a single clip is looked
+// up directly while many clips share one clip list request
+func (n *Nest) pollClips(clips []*Clip, done []bool) ([]*Clip, error) {
+	var waiting []*Clip
+	for i, clip := range clips {
+		if !done[i] {
+			waiting = append(waiting, clip)
+		}
+	}
+
+	if len(waiting) == 1 {
+		clip, err := n.GetClip(waiting[0].ID)
+		if err != nil {
+			return nil, err
+		}
+		return []*Clip{clip}, nil
+	}
+
+	return n.ListClips()
+}
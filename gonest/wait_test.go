@@ -0,0 +1,272 @@
+package gonest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPI serves the clip endpoints of the Nest API from memory
+type fakeAPI struct {
+	mu    sync.Mutex
+	clips []*Clip
+	polls int
+	// requests counts the requests made to each path
+	requests map[string]int
+	// poll, if set, is called with the lock held before every clip list or
+	// clip get is answered and can change the clips
+	poll func(polls int, clips []*Clip)
+
+	// quota, if set, refuses clip requests while that many clips exist
+	quota int
+	// refuse is how many clip requests are refused before any is accepted
+	refuse int
+	// pending leaves requested clips ungenerated, for poll to generate
+	pending bool
+	// most is the most clips there have been at once
+	most   int
+	nextID int
+	// video is served for every requested clip
+	video []byte
+	// deleting, if set, is called with the lock held before a clip is deleted
+	deleting func(id int)
+	deleted  []int
+}
+
+// newFakeAPI returns a Nest whose requests, whatever host they are for, are
+// answered by a fakeAPI holding clips
+func newFakeAPI(t *testing.T, clips ...*Clip) (*fakeAPI, *Nest) {
+	api := &fakeAPI{clips: clips, requests: make(map[string]int)}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nest := &Nest{}
+	nest.httpClient.Transport = rewriteTransport{target: target}
+	return api, nest
+}
+
+// rewriteTransport sends every request to target
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.URL.Scheme = r.target.Scheme
+	request.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests[r.URL.Path] += 1
+
+	switch r.URL.Path {
+	case "/api/clips.get_visible_with_quota":
+		a.polled()
+		json.NewEncoder(w).Encode(ClipListResponse{Items: []*Items{{Clips: a.clips}}})
+	case "/api/clips.get":
+		a.polled()
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		response := ClipGetResponse{Status: 404}
+		for _, clip := range a.clips {
+			if clip.ID == id {
+				response = ClipGetResponse{Clips: []*Clip{clip}}
+			}
+		}
+		json.NewEncoder(w).Encode(response)
+	case "/api/clips.request":
+		a.request(w, r)
+	case "/api/clips.delete":
+		body, _ := ioutil.ReadAll(r.Body)
+		form, _ := url.ParseQuery(string(body))
+		id, _ := strconv.Atoi(form.Get("id"))
+		var kept []*Clip
+		for _, clip := range a.clips {
+			if clip.ID != id {
+				kept = append(kept, clip)
+			}
+		}
+		if len(kept) == len(a.clips) {
+			http.NotFound(w, r)
+			return
+		}
+		if a.deleting != nil {
+			a.deleting(id)
+		}
+		a.clips = kept
+		a.deleted = append(a.deleted, id)
+	default:
+		if strings.HasPrefix(r.URL.Path, "/videos/") && a.video != nil {
+			w.Write(a.video)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// request answers clips.request, refusing it the way the server does when
+// the clip quota is full
+func (a *fakeAPI) request(w http.ResponseWriter, r *http.Request) {
+	if a.refuse > 0 || (a.quota > 0 && len(a.clips) >= a.quota) {
+		if a.refuse > 0 {
+			a.refuse -= 1
+		}
+		json.NewEncoder(w).Encode(ClipCreateResponse{Status: 400, StatusDescription: "clip quota exceeded"})
+		return
+	}
+
+	start, _ := strconv.ParseFloat(r.FormValue("start_date"), 64)
+	length, _ := strconv.ParseFloat(r.FormValue("length"), 64)
+	a.nextID += 1
+	clip := &Clip{
+		ID:             100 + a.nextID,
+		CameraUUID:     r.FormValue("uuid"),
+		StartTimeFloat: start,
+		Length:         length,
+		IsGenerated:    !a.pending,
+		DownloadURL:    fmt.Sprintf("https://clips.example/videos/%d.mp4", 100+a.nextID),
+	}
+	a.clips = append(a.clips, clip)
+	if len(a.clips) > a.most {
+		a.most = len(a.clips)
+	}
+	json.NewEncoder(w).Encode(ClipCreateResponse{Clips: []*Clip{clip}})
+}
+
+func (a *fakeAPI) polled() {
+	a.polls += 1
+	if a.poll != nil {
+		a.poll(a.polls, a.clips)
+	}
+}
+
+func (a *fakeAPI) count(path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests[path]
+}
+
+func TestWaitReady(t *testing.T) {
+	tests := []struct {
+		name     string
+		poll     func(polls int, clip *Clip)
+		cancel   int
+		expected error
+	}{
+		{
+			name: "generated",
+			poll: func(polls int, clip *Clip) {
+				if polls == 3 {
+					clip.IsGenerated = true
+					clip.DownloadURL = "https://clips.example/1.mp4"
+				}
+			},
+		},
+		{
+			name: "error",
+			poll: func(polls int, clip *Clip) {
+				clip.IsError = polls == 2
+			},
+			expected: ErrClipFailed,
+		},
+		{
+			name:     "timeout",
+			poll:     func(polls int, clip *Clip) {},
+			expected: ErrWaitTimeout,
+		},
+		{
+			name:     "cancelled",
+			poll:     func(polls int, clip *Clip) {},
+			cancel:   2,
+			expected: context.Canceled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api, nest := newFakeAPI(t, &Clip{ID: 1})
+			api.poll = func(polls int, clips []*Clip) {
+				test.poll(polls, clips[0])
+				if polls == test.cancel {
+					cancel()
+				}
+			}
+
+			var statuses []WaitStatus
+			clip := Clip{ID: 1, nest: nest}
+			ready, err := clip.WaitReady(ctx, WaitReadyOptions{
+				Timeout:  200 * time.Millisecond,
+				Interval: time.Millisecond,
+				Progress: func(status WaitStatus) {
+					statuses = append(statuses, status)
+				},
+			})
+			if err != test.expected {
+				t.Fatalf("got %v, expected %v", err, test.expected)
+			}
+			if err != nil {
+				return
+			}
+			if ready.DownloadURL != "https://clips.example/1.mp4" {
+				t.Errorf("clip was not refreshed, got download url %q", ready.DownloadURL)
+			}
+			if len(statuses) != 3 || statuses[2].Attempts != 3 || !statuses[2].Generated {
+				t.Errorf("got progress %+v, expected 3 polls ending generated", statuses)
+			}
+		})
+	}
+}
+
+func TestWaitClipsReady(t *testing.T) {
+	api, nest := newFakeAPI(t,
+		&Clip{ID: 1, IsGenerated: true},
+		&Clip{ID: 2},
+		&Clip{ID: 3},
+	)
+	api.poll = func(polls int, clips []*Clip) {
+		clips[1].IsGenerated = polls >= 4
+		clips[2].IsError = polls >= 2
+	}
+
+	clips := []*Clip{{ID: 1}, {ID: 2}, {ID: 3}}
+	errs := nest.WaitClipsReady(context.Background(), clips, WaitReadyOptions{
+		Timeout:  200 * time.Millisecond,
+		Interval: time.Millisecond,
+	})
+
+	expected := []error{nil, nil, ErrClipFailed}
+	for i, err := range errs {
+		if err != expected[i] {
+			t.Errorf("clip %d got %v, expected %v", clips[i].ID, err, expected[i])
+		}
+	}
+	if !clips[1].IsGenerated {
+		t.Error("clip 2 was not refreshed in place")
+	}
+
+	// several waiting clips share a clip list request, the last one left is
+	// looked up on its own
+	lists := api.count("/api/clips.get_visible_with_quota")
+	gets := api.count("/api/clips.get")
+	if lists != 2 || gets != 2 {
+		t.Errorf("got %d clip list and %d clip requests, expected 2 of each", lists, gets)
+	}
+}
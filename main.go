@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -16,24 +19,70 @@ import (
 
 var nest gonest.Nest
 
+// perDownloadRate is the --limit-rate-per-download flag in bytes per second
+var perDownloadRate int64
+
 func main() {
 	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "limit-rate",
+			Usage: "limit all downloads together to this many bytes per second, e.g. 500k or 2M",
+		},
+		cli.StringFlag{
+			Name:  "limit-rate-per-download",
+			Usage: "limit each download to this many bytes per second",
+		},
+		cli.StringFlag{
+			Name:  "limit-rate-schedule",
+			Usage: "time of day overrides for --limit-rate, e.g. 08:00-23:00=512k,23:00-08:00=0",
+		},
+		cli.DurationFlag{
+			Name:  "max-clip-length",
+			Usage: "longest clip requested from the server at once, the server does not report its limit so one hour is assumed",
+			Value: gonest.MaxClipLength,
+		},
+		cli.StringFlag{
+			Name:   "archive-root",
+			Usage:  "default archive directory, relative --directory values are resolved against it",
+			EnvVar: "GONEST_ARCHIVE_ROOT",
+		},
+		cli.StringFlag{
+			Name:   "dir-mode",
+			Usage:  "octal permission for directories gonest creates, defaults to 0755",
+			EnvVar: "GONEST_DIR_MODE",
+		},
+		cli.BoolFlag{
+			Name:   "camera-dirs",
+			Usage:  "save clips in a subdirectory per camera",
+			EnvVar: "GONEST_CAMERA_DIRS",
+		},
+	}
+	app.Before = configure
 	app.Commands = []cli.Command{
 		{
 			Name:    "download-clip",
 			Aliases: []string{},
 			Usage:   "download a specific clip",
 			Action:  DownloadClip,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.Int64Flag{
 					Name:  "id",
 					Usage: "clip id",
 				},
 				cli.StringFlag{
 					Name:  "filename",
-					Usage: "filename to save clip to",
+					Usage: "filename to save clip to, - for stdout",
 				},
-			},
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "archive directory the name template and manifest are relative to, defaults to --archive-root or the working directory",
+				},
+				cli.BoolFlag{
+					Name:  "thumbnails",
+					Usage: "also save the clip thumbnail next to the video",
+				},
+			}, append(nameTemplateFlags(gonest.DefaultClipNameTemplate), saveOptionFlags()...)...),
 		},
 		{
 			Name:    "delete-clip",
@@ -47,33 +96,353 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:    "delete-clips",
+			Aliases: []string{},
+			Usage:   "delete all clips matching the given selectors",
+			Action:  DeleteClips,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "only clips generated longer ago than this",
+				},
+				cli.StringFlag{
+					Name:  "camera",
+					Usage: "only clips from this camera uuid",
+				},
+				cli.StringFlag{
+					Name:  "title",
+					Usage: "only clips with a title matching this regular expression",
+				},
+				cli.BoolFlag{
+					Name:  "created-by-gonest",
+					Usage: "only clips created by gonest",
+				},
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "allow deleting without any selector",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show which clips would be deleted",
+				},
+				cli.BoolFlag{
+					Name:  "yes",
+					Usage: "do not ask for confirmation",
+				},
+				cli.IntFlag{
+					Name:  "concurrency",
+					Usage: "number of deletes to run at once",
+					Value: 4,
+				},
+			},
+		},
 		{
 			Name:    "download-clips",
 			Aliases: []string{},
 			Usage:   "download all clips",
 			Action:  DownloadClips,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "directory",
-					Usage: "directory to save clips to",
+					Usage: "directory to save clips to, defaults to --archive-root",
 				},
-			},
+				cli.BoolFlag{
+					Name:  "thumbnails",
+					Usage: "also save clip thumbnails next to the videos",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "number of clips to download at once",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "per-host",
+					Usage: "maximum downloads at once from a single clip server, 0 for no limit",
+				},
+				cli.Float64Flag{
+					Name:  "requests-per-second",
+					Usage: "maximum downloads started per second across all workers, 0 for no limit",
+				},
+			}, append(nameTemplateFlags("{{.Filename}}"), saveOptionFlags()...)...),
 		},
 		{
 			Name:    "download-video",
 			Aliases: []string{},
 			Usage:   "download video by creating and deleting clips",
 			Action:  DownloadVideo,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "id",
 					Usage: "camera id",
 				},
-				cli.Int64Flag{
-					Name: "start",
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "archive directory the name template and manifest are relative to, defaults to --archive-root or the working directory",
 				},
-				cli.Int64Flag{
-					Name: "end",
+				cli.StringFlag{
+					Name:  "start",
+					Usage: "start time as unix seconds, RFC3339, a local \"2006-01-02 15:04\" in --tz, \"yesterday\" or an offset such as \"-24h\"",
+				},
+				cli.StringFlag{
+					Name:  "end",
+					Usage: "end time in any --start format, defaults to now",
+				},
+				cli.StringFlag{
+					Name:  "day",
+					Usage: "a whole local day such as 2026-10-01 instead of --start and --end",
+				},
+				cli.DurationFlag{
+					Name:  "gap-tolerance",
+					Usage: "ignore gaps and overlaps between clips up to this long",
+					Value: 5 * time.Second,
+				},
+				cli.BoolFlag{
+					Name:  "fill-gaps",
+					Usage: "request missing parts of the range again",
+				},
+				cli.StringFlag{
+					Name:  "checkpoint",
+					Usage: "checkpoint file used to resume an interrupted run, defaults to any checkpoint in --directory for the camera which overlaps the range",
+				},
+				cli.StringFlag{
+					Name:  "concat",
+					Usage: "join the finished segments into one file per \"day\" or for the whole \"range\", never across gaps",
+				},
+				cli.BoolFlag{
+					Name:  "concat-remove-segments",
+					Usage: "remove the segment files once they have been joined",
+				},
+				cli.BoolFlag{
+					Name:  "events",
+					Usage: "only download the parts of the range around camera events",
+				},
+				cli.StringFlag{
+					Name:  "event-types",
+					Usage: "comma separated event types to download, such as motion,person, empty for all",
+				},
+				cli.DurationFlag{
+					Name:  "pre-roll",
+					Usage: "video to include before each event",
+					Value: 10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "post-roll",
+					Usage: "video to include after each event",
+					Value: 10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "event-merge-gap",
+					Usage: "merge events which are closer together than this into one clip",
+					Value: 30 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "segment",
+					Usage: "length of each clip, longer segments are split at --max-clip-length",
+					Value: gonest.MaxClipLength,
+				},
+			}, append(append(nameTemplateFlags(gonest.DefaultVideoNameTemplate), exportFlags()...), saveOptionFlags()...)...),
+		},
+		{
+			Name:    "coverage",
+			Aliases: []string{},
+			Usage:   "report gaps and overlaps in the downloaded video of a time range",
+			Action:  Coverage,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "camera id, defaults to every camera in the manifest",
+				},
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "archive directory, defaults to --archive-root or the working directory",
+				},
+				cli.StringFlag{
+					Name:  "start",
+					Usage: "start time as unix seconds, RFC3339, a local \"2006-01-02 15:04\" in --tz, \"yesterday\" or an offset such as \"-24h\"",
+				},
+				cli.StringFlag{
+					Name:  "end",
+					Usage: "end time in any --start format, defaults to now",
+				},
+				cli.StringFlag{
+					Name:  "day",
+					Usage: "a whole local day such as 2026-10-01 instead of --start and --end",
+				},
+				cli.DurationFlag{
+					Name:  "gap-tolerance",
+					Usage: "ignore gaps and overlaps between clips up to this long",
+					Value: 5 * time.Second,
+				},
+				cli.BoolFlag{
+					Name:  "fill-gaps",
+					Usage: "request missing parts of the range again",
+				},
+				cli.BoolFlag{
+					Name:  "yes",
+					Usage: "do not ask before requesting missing ranges",
+				},
+			}, append(append(nameTemplateFlags(gonest.DefaultVideoNameTemplate), exportFlags()...), saveOptionFlags()...)...),
+		},
+		{
+			Name:    "archive",
+			Aliases: []string{},
+			Usage:   "continuously archive camera history using per-camera schedules from a config file",
+			Action:  Archive,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "json archive config with the directory and a schedule per camera",
+				},
+				cli.BoolFlag{
+					Name:  "once",
+					Usage: "archive the finished windows once and exit",
+				},
+			}, append(exportFlags(), saveOptionFlags()...)...),
+		},
+		{
+			Name:    "sync-clips",
+			Aliases: []string{},
+			Usage:   "reconcile the clip list with a local archive directory",
+			Action:  SyncClips,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "archive directory, defaults to --archive-root",
+				},
+				cli.BoolFlag{
+					Name:  "delete-local",
+					Usage: "delete local copies of clips which were deleted in the app",
+				},
+				cli.IntFlag{
+					Name:  "delete-remote-after-days",
+					Usage: "delete clips from the server once they are verified locally and this many days old, 0 never deletes",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only print the plan",
+				},
+				cli.BoolFlag{
+					Name:  "yes",
+					Usage: "do not ask for confirmation before deleting",
+				},
+				cli.BoolFlag{
+					Name:  "thumbnails",
+					Usage: "also save clip thumbnails next to the videos",
+				},
+				cli.IntFlag{
+					Name:  "parallel",
+					Usage: "number of clips to download or delete at once",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "per-host",
+					Usage: "maximum downloads at once from a single clip server, 0 for no limit",
+				},
+				cli.Float64Flag{
+					Name:  "requests-per-second",
+					Usage: "maximum downloads started per second across all workers, 0 for no limit",
+				},
+			}, append(nameTemplateFlags("{{.Filename}}"), saveOptionFlags()...)...),
+		},
+		{
+			Name:    "verify",
+			Aliases: []string{},
+			Usage:   "check downloaded clips for truncated or corrupt files",
+			Action:  Verify,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "directory to scan, defaults to --archive-root",
+				},
+				cli.BoolFlag{
+					Name:  "remote",
+					Usage: "compare durations of files in the manifest against their clips in the clip list",
+				},
+				cli.BoolFlag{
+					Name:  "quarantine",
+					Usage: "rename bad files to <file>.corrupt so they are downloaded again",
+				},
+			},
+		},
+		{
+			Name:    "manifest",
+			Aliases: []string{},
+			Usage:   "manage the download manifest of an archive directory",
+			Subcommands: []cli.Command{
+				{
+					Name:   "import",
+					Usage:  "adopt existing files in the archive directory into the manifest",
+					Action: ManifestImport,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "directory",
+							Usage: "archive directory, defaults to --archive-root",
+						},
+						cli.BoolFlag{
+							Name:  "offline",
+							Usage: "only match files using their sidecars, without listing clips",
+						},
+					},
+				},
+				{
+					Name:   "keep",
+					Usage:  "protect a clip from the retention policy",
+					Action: ManifestKeep,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "directory",
+							Usage: "archive directory, defaults to --archive-root",
+						},
+						cli.IntFlag{
+							Name:  "id",
+							Usage: "clip id",
+						},
+						cli.BoolFlag{
+							Name:  "unset",
+							Usage: "remove the keep flag instead",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:    "prune",
+			Aliases: []string{},
+			Usage:   "remove archived video according to a retention policy",
+			Action:  Prune,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "directory",
+					Usage: "archive directory, defaults to --archive-root",
+				},
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "use the retention rules and directory of an archive config",
+				},
+				cli.StringFlag{
+					Name:  "camera",
+					Usage: "only apply the rule to this camera uuid",
+				},
+				cli.IntFlag{
+					Name:  "keep-days",
+					Usage: "remove video older than this many days",
+				},
+				cli.Float64Flag{
+					Name:  "max-gb",
+					Usage: "remove the oldest video of each camera beyond this many GB",
+				},
+				cli.IntFlag{
+					Name:  "hourly-after-days",
+					Usage: "keep only one clip per hour of video older than this many days",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show what would be removed",
+				},
+				cli.BoolFlag{
+					Name:  "yes",
+					Usage: "do not ask for confirmation",
 				},
 			},
 		},
@@ -97,7 +466,50 @@ func main() {
 			Flags:   []cli.Flag{},
 		},
 	}
-	app.Run(os.Args)
+	err := app.Run(os.Args)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("invalid options")
+	}
+}
+
+func configure(c *cli.Context) error {
+	err := configureDirectories(c)
+	if err != nil {
+		return err
+	}
+	err = configureBandwidth(c)
+	if err != nil {
+		return err
+	}
+	return configureClipLength(c)
+}
+
+func configureClipLength(c *cli.Context) error {
+	length := c.GlobalDuration("max-clip-length")
+	if length < time.Second {
+		return fmt.Errorf("max clip length %s is shorter than a second", length)
+	}
+	gonest.MaxClipLength = length
+	return nil
+}
+
+func configureBandwidth(c *cli.Context) error {
+	rate, err := gonest.ParseRate(c.String("limit-rate"))
+	if err != nil {
+		return err
+	}
+	schedule, err := gonest.ParseSchedule(c.String("limit-rate-schedule"))
+	if err != nil {
+		return err
+	}
+	if rate > 0 || len(schedule) > 0 {
+		nest.Bandwidth = gonest.NewBandwidthLimiter(rate, schedule)
+	}
+
+	perDownloadRate, err = gonest.ParseRate(c.String("limit-rate-per-download"))
+	return err
 }
 
 func ParseCookie(c *cli.Context) {
@@ -128,10 +540,10 @@ func DownloadClips(c *cli.Context) {
 	nest.Login()
 	nest.Save()
 
-	directory := c.String("directory")
-	if directory == "" {
-		log.Fatal("directory is required")
-	}
+	directory := requireArchiveDirectory(c)
+
+	manifest := loadManifest(directory)
+	cleanupPartials(c, directory)
 
 	log.Info("listing clips")
 	clips, err := nest.ListClips()
@@ -139,27 +551,170 @@ func DownloadClips(c *cli.Context) {
 		panic(err)
 	}
 
+	markRemoteDeleted(manifest, clips)
+
+	summary := downloadClips(c, directory, manifest, clips)
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func markRemoteDeleted(manifest *gonest.Manifest, clips []*gonest.Clip) {
+	for _, entry := range manifest.MarkRemoteDeleted(clips) {
+		log.WithFields(log.Fields{
+			"id":    entry.ID,
+			"path":  entry.Path,
+			"title": entry.Title,
+		}).Info("clip was deleted from the server")
+	}
+	saveManifest(manifest)
+}
+
+// downloadClips saves clips into directory using the download flags of c,
+// recording every result in the manifest
+func downloadClips(c *cli.Context, directory string, manifest *gonest.Manifest, clips []*gonest.Clip) gonest.DownloadSummary {
+	namer := newClipNamer(c)
+	var jobs []gonest.DownloadJob
 	for _, clip := range clips {
-		filename := fmt.Sprintf("%s/%s", directory, clip.Filename)
-		_, err := os.Stat(filename)
-		if err == nil {
+		job := gonest.DownloadJob{
+			Clip:     clip,
+			Filename: filepath.Join(directory, namer.ClipFilename(clip)),
+		}
+		if c.Bool("thumbnails") {
+			job.Thumbnail = thumbnailFilename(job.Filename)
+		}
+		jobs = append(jobs, job)
+	}
+
+	progress := newProgressDisplay(len(jobs))
+	downloader := gonest.Downloader{
+		Workers:           c.Int("parallel"),
+		PerHost:           c.Int("per-host"),
+		RequestsPerSecond: c.Float64("requests-per-second"),
+		Options:           saveOptions(c, progress),
+		Skip: func(job gonest.DownloadJob) bool {
+			if manifest.Pruned(job.Clip.ID) {
+				return true
+			}
+			if manifest.Complete(job.Clip.ID) {
+				return job.Thumbnail == "" || gonest.FileExists(job.Thumbnail)
+			}
+			return gonest.JobComplete(job)
+		},
+		Result: func(result gonest.DownloadResult) {
+			progress.Finished()
+			fields := log.Fields{
+				"filename": result.Job.Filename,
+				"title":    result.Job.Clip.Title,
+			}
+			switch result.Status {
+			case gonest.DownloadSaved:
+				fields["duration"] = result.Duration
+				log.WithFields(fields).Info("saved clip")
+				recordManifest(manifest, result.Job.Clip, result.Job.Filename, gonest.ManifestFromClips)
+			case gonest.DownloadSkipped:
+				log.WithFields(fields).Debug("skipped clip")
+				if !manifest.Complete(result.Job.Clip.ID) && !manifest.Pruned(result.Job.Clip.ID) {
+					recordManifest(manifest, result.Job.Clip, result.Job.Filename, gonest.ManifestFromClips)
+				}
+			case gonest.DownloadFailed:
+				fields["error"] = result.Error
+				log.WithFields(fields).Error("failed saving clip")
+				manifest.RecordFailure(result.Job.Clip, result.Job.Filename, result.Error)
+				saveManifest(manifest)
+			}
+		},
+	}
+	summary := downloader.Run(jobs)
+	progress.Close()
+
+	log.WithFields(log.Fields{
+		"saved":   summary.Saved,
+		"skipped": summary.Skipped,
+		"failed":  summary.Failed,
+	}).Info("finished downloading clips")
+	return summary
+}
+
+func Verify(c *cli.Context) {
+	directory := requireArchiveDirectory(c)
+
+	remote := make(map[int]float64)
+	if c.Bool("remote") {
+		nest.Load()
+		nest.Login()
+		nest.Save()
+
+		clips, err := nest.ListClips()
+		if err != nil {
+			panic(err)
+		}
+		for _, clip := range clips {
+			remote[clip.ID] = clip.Length
+		}
+	}
+
+	// files in the manifest are checked against the clip they were saved
+	// from while it is on the server, otherwise against the time they were
+	// recorded as covering, which is all there is for joined video
+	lengths := make(map[string]float64)
+	manifest := loadManifest(directory)
+	for _, entry := range manifest.List() {
+		if entry.Status != gonest.ManifestComplete {
 			continue
 		}
-		log.WithFields(log.Fields{
-			"filename": filename,
-			"title":    clip.Title,
-		}).Info("saving clip")
-		err = clip.Save(filename)
+		length, ok := remote[entry.ID]
+		if !ok {
+			length = entry.End.Sub(entry.Start).Seconds()
+		}
+		lengths[filepath.Clean(manifest.FullPath(entry))] = length
+	}
+
+	checked := 0
+	bad := 0
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.WithFields(log.Fields{
-				"filename": filename,
-				"title":    clip.Title,
-				"error":    err,
-			}).Error("failed saving clip")
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".mp4" {
+			return nil
+		}
+
+		checked += 1
+		err = gonest.VerifyMP4(path, lengths[filepath.Clean(path)])
+		if err == nil {
+			return nil
 		}
+
+		bad += 1
+		log.WithFields(log.Fields{
+			"filename": path,
+			"error":    err,
+		}).Error("file failed verification")
+		if c.Bool("quarantine") {
+			err = os.Rename(path, path+".corrupt")
+			if err != nil {
+				log.WithFields(log.Fields{
+					"filename": path,
+					"error":    err,
+				}).Error("failed quarantining file")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
 	}
 
+	log.WithFields(log.Fields{
+		"checked": checked,
+		"bad":     bad,
+	}).Info("finished verifying files")
+	if bad > 0 {
+		os.Exit(1)
+	}
 }
+
 func ListClips(c *cli.Context) {
 	nest.Load()
 	nest.Login()
@@ -183,24 +738,268 @@ func DownloadClip(c *cli.Context) {
 		log.Fatal("id is required")
 	}
 
+	nest.Load()
+	nest.Login()
+	nest.Save()
+
+	clip := getClip(id)
+	directory := archiveDirectory(c, ".")
 	filename := c.String("filename")
 	if filename == "" {
-		filename = fmt.Sprintf("videos/%d.mp4", id)
+		filename = filepath.Join(directory, newClipNamer(c).ClipFilename(clip))
+	}
+	if filename == "-" {
+		_, err := clip.WriteToWithOptions(os.Stdout, gonest.SaveOptions{RateLimit: perDownloadRate})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":    id,
+				"error": err,
+			}).Fatal("failed writing clip to stdout")
+		}
+		return
 	}
 
-	nest.Load()
-	nest.Login()
-	nest.Save()
+	manifest := loadManifest(directory)
+	dirs := []string{directory}
+	if !insideDirectory(directory, filename) {
+		dirs = append(dirs, filepath.Dir(filename))
+	}
+	cleanupPartials(c, dirs...)
+	entry, ok := manifest.Get(id)
+	if ok && manifest.Complete(id) && manifest.FullPath(entry) == filepath.Clean(filename) {
+		log.WithFields(log.Fields{
+			"id":       id,
+			"filename": filename,
+		}).Info("clip already downloaded")
+		if c.Bool("sidecar") && !gonest.FileExists(gonest.SidecarFilename(filename)) {
+			err := clip.WriteSidecar(filename)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"filename": filename,
+					"error":    err,
+				}).Fatal("failed writing sidecar")
+			}
+		}
+	} else {
+		progress := newProgressDisplay(1)
+		err := clip.SaveWithOptions(filename, saveOptions(c, progress))
+		progress.Close()
+		if err != nil {
+			manifest.RecordFailure(clip, filename, err)
+			saveManifest(manifest)
+			log.WithFields(log.Fields{
+				"filename": filename,
+				"error":    err,
+			}).Fatal("failed saving clip")
+		}
+		recordManifest(manifest, clip, filename, gonest.ManifestFromClips)
+	}
+	if c.Bool("thumbnails") {
+		saveThumbnail(clip, filename)
+	}
+}
 
-	clips, err := nest.ListClips()
+func saveOptionFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  "sidecar",
+			Usage: "write a .json file with the clip record, download time and sha256 next to each video",
+		},
+		cli.BoolFlag{
+			Name:  "embed-metadata",
+			Usage: "store the clip start time and title in the mp4 metadata",
+		},
+		cli.StringFlag{
+			Name:  "staging-dir",
+			Usage: "write partial downloads here, must be on the same filesystem as the destination",
+		},
+		cli.DurationFlag{
+			Name:  "partial-max-age",
+			Usage: "remove partial downloads which can not be resumed once they are this old",
+			Value: time.Hour,
+		},
+	}
+}
+
+// cleanupPartials removes or reports partial downloads left by a crash in
+// dirs, which are where the command writes, and in the staging directory
+func cleanupPartials(c *cli.Context, dirs ...string) {
+	if c.String("staging-dir") != "" {
+		dirs = append(dirs, archivePath(c.String("staging-dir")))
+	}
+	for _, dir := range dirs {
+		result, err := gonest.CleanupPartialDownloads(dir, c.Duration("partial-max-age"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"directory": dir,
+				"error":     err,
+			}).Error("failed cleaning up partial downloads")
+			continue
+		}
+		for _, filename := range result.Removed {
+			log.WithFields(log.Fields{
+				"filename": filename,
+			}).Info("removed stale partial download")
+		}
+		if len(result.Resumable) > 0 {
+			log.WithFields(log.Fields{
+				"directory": dir,
+				"count":     len(result.Resumable),
+			}).Info("found partial downloads which will be resumed")
+		}
+	}
+}
+
+// insideDirectory reports if filename is somewhere below directory
+func insideDirectory(directory string, filename string) bool {
+	rel, err := filepath.Rel(directory, filename)
 	if err != nil {
-		panic(err)
+		return false
 	}
-	for _, clip := range clips {
-		if clip.ID == id {
-			clip.Save(filename)
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func saveOptions(c *cli.Context, progress *progressDisplay) gonest.SaveOptions {
+	return gonest.SaveOptions{
+		Progress:      progress.Update,
+		Sidecar:       c.Bool("sidecar"),
+		EmbedMetadata: c.Bool("embed-metadata"),
+		RateLimit:     perDownloadRate,
+		StagingDir:    archivePath(c.String("staging-dir")),
+	}
+}
+
+// exportFlags are shared by the commands which export video through
+// temporary clips
+func exportFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of clips requested from the server at once",
+			Value: 2,
+		},
+		cli.BoolFlag{
+			Name:  "keep-clips",
+			Usage: "leave clips on the server after saving them",
+		},
+		cli.BoolFlag{
+			Name:  "delete-on-failure",
+			Usage: "delete clips from the server even when saving them failed",
+		},
+	}
+}
+
+func nameTemplateFlags(defaultTemplate string) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "name-template",
+			Usage: "text/template for filenames using .ID .UUID .Camera .Title .Start .End .Length .Filename",
+			Value: defaultTemplate,
+		},
+		cli.StringFlag{
+			Name:  "tz",
+			Usage: "timezone for local times and for .Start and .End in the name template",
+			Value: "Local",
+		},
+	}
+}
+
+type clipNamer struct {
+	template *gonest.NameTemplate
+	cameras  map[string]string
+}
+
+func timezone(c *cli.Context) *time.Location {
+	location, err := time.LoadLocation(c.String("tz"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"tz":    c.String("tz"),
+			"error": err,
+		}).Fatal("invalid timezone")
+	}
+	return location
+}
+
+// newClipNamer parses the name template flags, camera names are only looked
+// up when the template or --camera-dirs uses them
+func newClipNamer(c *cli.Context) *clipNamer {
+	return makeClipNamer(c.String("name-template"), timezone(c))
+}
+
+func makeClipNamer(text string, location *time.Location) *clipNamer {
+	template, err := gonest.ParseNameTemplate(text, location)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"template": text,
+			"error":    err,
+		}).Fatal("invalid name template")
+	}
+
+	template.CameraDirectories = cameraDirectories
+
+	namer := &clipNamer{
+		template: template,
+		cameras:  make(map[string]string),
+	}
+	if strings.Contains(text, ".Camera") || cameraDirectories {
+		cameras, err := nest.CameraNames()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Warn("failed listing cameras, using camera uuids as names")
+		} else {
+			namer.cameras = cameras
 		}
 	}
+	return namer
+}
+
+func (n *clipNamer) Filename(name gonest.ClipName) string {
+	filename, err := n.template.Execute(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    name.ID,
+			"error": err,
+		}).Fatal("failed executing name template")
+	}
+	return filename
+}
+
+func (n *clipNamer) ClipFilename(clip *gonest.Clip) string {
+	return n.Filename(gonest.NewClipName(clip, n.cameras[clip.CameraUUID]))
+}
+
+func getClip(id int) *gonest.Clip {
+	clip, err := nest.GetClip(id)
+	if err == gonest.ErrNotFound {
+		log.WithFields(log.Fields{
+			"id": id,
+		}).Fatal("clip not found")
+	}
+	if err != nil {
+		panic(err)
+	}
+	return clip
+}
+
+func thumbnailFilename(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+}
+
+func saveThumbnail(clip *gonest.Clip, filename string) {
+	thumbnail := thumbnailFilename(filename)
+	_, err := os.Stat(thumbnail)
+	if err == nil {
+		return
+	}
+	err = clip.SaveThumbnail(thumbnail)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"filename": thumbnail,
+			"title":    clip.Title,
+			"error":    err,
+		}).Error("failed saving thumbnail")
+	}
 }
 
 func DeleteClip(c *cli.Context) {
@@ -213,44 +1012,526 @@ func DeleteClip(c *cli.Context) {
 	nest.Login()
 	nest.Save()
 
-	clips, err := nest.ListClips()
+	clip := getClip(id)
+	err := clip.Delete()
+	nest.Save()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Fatal("failed deleting clip")
+	}
+}
+
+func DeleteClips(c *cli.Context) {
+	filter := gonest.ClipFilter{
+		OlderThan:       c.Duration("older-than"),
+		CameraUUID:      c.String("camera"),
+		CreatedByGonest: c.Bool("created-by-gonest"),
+	}
+	if c.String("title") != "" {
+		title, err := regexp.Compile(c.String("title"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"title": c.String("title"),
+				"error": err,
+			}).Fatal("invalid title pattern")
+		}
+		filter.Title = title
+	}
+	if filter == (gonest.ClipFilter{}) && !c.Bool("all") {
+		log.Fatal("at least one selector or --all is required")
+	}
+
+	nest.Load()
+	nest.Login()
+	nest.Save()
+
+	clips, err := nest.FilterClips(filter)
 	if err != nil {
 		panic(err)
 	}
+	nest.Save()
+
+	if len(clips) == 0 {
+		log.Info("no clips matched")
+		return
+	}
+
 	for _, clip := range clips {
-		if clip.ID == id {
-			clip.Delete()
+		fmt.Printf("%d\t%s\t%s\t%s\n", clip.ID, clip.StartTime().Format(time.RFC3339), clip.CameraUUID, clip.Title)
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Printf("would delete %d clips\n", len(clips))
+		return
+	}
+
+	if !c.Bool("yes") && !confirm(fmt.Sprintf("Delete %d clips?", len(clips))) {
+		log.Info("not deleting clips")
+		return
+	}
+
+	results := nest.DeleteClipList(clips, c.Int("concurrency"))
+	nest.Save()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed += 1
+			log.WithFields(log.Fields{
+				"id":    result.Clip.ID,
+				"title": result.Clip.Title,
+				"error": result.Error,
+			}).Error("failed deleting clip")
+		} else {
+			log.WithFields(log.Fields{
+				"id":    result.Clip.ID,
+				"title": result.Clip.Title,
+			}).Info("deleted clip")
 		}
 	}
+
+	log.WithFields(log.Fields{
+		"deleted": len(results) - failed,
+		"failed":  failed,
+	}).Info("finished deleting clips")
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
 
-func DownloadVideo(c *cli.Context) {
-	start := c.Int64("start")
-	if start == 0 {
-		log.Fatal("start is required")
+// videoRange builds the requested range from --day or --start and --end
+func videoRange(c *cli.Context) gonest.TimeRange {
+	location := timezone(c)
+	now := time.Now()
+
+	if c.IsSet("day") {
+		if c.IsSet("start") || c.IsSet("end") {
+			log.Fatal("day can not be combined with start or end")
+		}
+		day, err := gonest.DayRange(c.String("day"), location, now)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"day":   c.String("day"),
+				"error": err,
+			}).Fatal("invalid day")
+		}
+		return day
 	}
 
-	end := c.Int64("end")
-	if end == 0 {
-		log.Fatal("end is required")
+	if c.String("start") == "" {
+		log.Fatal("start or day is required")
+	}
+	start, err := gonest.ParseTime(c.String("start"), location, now)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"start": c.String("start"),
+			"error": err,
+		}).Fatal("invalid start")
 	}
 
+	end := now
+	if c.String("end") != "" {
+		end, err = gonest.ParseTime(c.String("end"), location, now)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"end":   c.String("end"),
+				"error": err,
+			}).Fatal("invalid end")
+		}
+	}
+	return gonest.TimeRange{Start: start, End: end}
+}
+
+func videoSegments(c *cli.Context, intervals []gonest.TimeRange) []gonest.TimeRange {
+	segment := c.Duration("segment")
+	if segment > gonest.MaxClipLength {
+		log.WithFields(log.Fields{
+			"segment": segment,
+			"maximum": gonest.MaxClipLength,
+		}).Warn("segment is longer than the maximum clip length, splitting")
+	}
+
+	var segments []gonest.TimeRange
+	for _, interval := range intervals {
+		split, err := gonest.SplitRange(interval, segment)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"range":   interval,
+				"segment": segment,
+				"error":   err,
+			}).Fatal("invalid range")
+		}
+		segments = append(segments, split...)
+	}
+	return segments
+}
+
+// eventIntervals asks the camera for its events during requested and returns
+// the padded and merged ranges around them
+func eventIntervals(c *cli.Context, id string, requested gonest.TimeRange) []gonest.TimeRange {
+	events, err := nest.CameraEvents(id, requested)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Fatal("failed listing camera events")
+	}
+
+	var types []string
+	for _, eventType := range strings.Split(c.String("event-types"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			types = append(types, eventType)
+		}
+	}
+
+	intervals := gonest.EventIntervals(events, types, c.Duration("pre-roll"), c.Duration("post-roll"), c.Duration("event-merge-gap"), requested)
+	var total time.Duration
+	for _, interval := range intervals {
+		total += interval.Length()
+	}
+	log.WithFields(log.Fields{
+		"events":    len(events),
+		"intervals": len(intervals),
+		"video":     total,
+		"requested": requested.Length(),
+	}).Info("found events")
+	return intervals
+}
+
+func DownloadVideo(c *cli.Context) {
 	id := c.String("id")
 	if id == "" {
 		log.Fatal("id is required")
 	}
 
+	requested := videoRange(c)
+
 	nest.Load()
 	nest.Login()
 	nest.Save()
 
-	for i := start; i < end; i += 3600 {
-		clip, err := nest.CreateClip(id, time.Unix(i, 0), 3600)
+	intervals := []gonest.TimeRange{requested}
+	if c.Bool("events") {
+		intervals = eventIntervals(c, id, requested)
+		if len(intervals) == 0 {
+			log.WithFields(log.Fields{
+				"range": requested,
+			}).Info("no events in range")
+			return
+		}
+	}
+	segments := videoSegments(c, intervals)
+
+	log.WithFields(log.Fields{
+		"range":     requested,
+		"intervals": len(intervals),
+		"segments":  len(segments),
+	}).Info("downloading video")
+
+	directory := archiveDirectory(c, ".")
+	manifest := loadManifest(directory)
+	cleanupPartials(c, directory)
+	namer := newClipNamer(c)
+
+	checkpointFile := archivePath(c.String("checkpoint"))
+	if checkpointFile == "" {
+		// relative ranges such as -24h move on every run, so any checkpoint
+		// of this camera overlapping the range is picked up
+		checkpointFile = gonest.FindCheckpoint(directory, id, requested)
+	}
+	checkpoint, err := gonest.LoadCheckpoint(checkpointFile, id, requested)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"checkpoint": checkpointFile,
+			"error":      err,
+		}).Fatal("failed loading checkpoint")
+	}
+	failed, left := exportVideo(c, id, directory, segments, checkpoint, namer, manifest)
+	reportLeftClips(left)
+
+	if failed > 0 || len(checkpoint.Leftovers()) > 0 {
+		log.WithFields(log.Fields{
+			"checkpoint": checkpointFile,
+			"failed":     failed,
+			"leftovers":  len(checkpoint.Leftovers()),
+		}).Error("video incomplete, run again to resume")
+		os.Exit(1)
+	}
+
+	for _, interval := range intervals {
+		coverage := checkCoverage(c, manifest, id, interval)
+		if !coverage.Complete() && c.Bool("fill-gaps") {
+			filled, left := fillGaps(c, id, directory, coverage, namer, manifest)
+			saveCheckpoint(checkpoint.AddFills(filled))
+			reportLeftClips(left)
+			checkCoverage(c, manifest, id, interval)
+		}
+	}
+
+	if c.String("concat") != "" {
+		concatVideo(c, id, checkpoint, namer, manifest)
+	}
+
+	err = checkpoint.Remove()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"checkpoint": checkpointFile,
+			"error":      err,
+		}).Error("failed removing checkpoint")
+	}
+}
+
+// exportVideo exports the segments of camera id which the checkpoint doesn't
+// have yet, recording every result in the checkpoint and manifest. It returns
+// how many segments failed and the results whose clips are still on the
+// server.
+func exportVideo(c *cli.Context, id string, directory string, segments []gonest.TimeRange, checkpoint *gonest.Checkpoint, namer *clipNamer, manifest *gonest.Manifest) (int, []gonest.ExportResult) {
+	pending := checkpoint.Pending(segments)
+	completed := len(checkpoint.Segments())
+	if completed > 0 {
+		log.WithFields(log.Fields{
+			"checkpoint": checkpoint.Filename,
+			"completed":  completed,
+			"pending":    len(pending),
+		}).Info("resuming from checkpoint")
+	}
+	pending, reuse := nest.RecoverLeftovers(checkpoint, pending)
+	nest.Save()
+
+	progress := newProgressDisplay(len(pending))
+
+	failed := 0
+	var left []gonest.ExportResult
+	exporter := &gonest.Exporter{
+		Concurrency:     c.Int("concurrency"),
+		KeepClips:       c.Bool("keep-clips"),
+		DeleteOnFailure: c.Bool("delete-on-failure"),
+		Reuse:           reuse,
+		Filename: func(segment gonest.TimeRange, clip *gonest.Clip) string {
+			name := gonest.NewClipName(clip, namer.cameras[id])
+			name.UUID = id
+			name.Start = segment.Start
+			name.End = segment.End
+			return filepath.Join(directory, namer.Filename(name))
+		},
+		Created: func(segment gonest.TimeRange, clip *gonest.Clip) {
+			log.WithFields(log.Fields{
+				"id":      clip.ID,
+				"segment": segment,
+			}).Info("requested clip")
+			saveCheckpoint(checkpoint.Started(segment, clip))
+			nest.Save()
+		},
+		Result: func(result gonest.ExportResult) {
+			progress.Finished()
+			saveCheckpoint(checkpoint.Finished(result))
+			if result.Error != nil {
+				failed += 1
+			}
+			if result.Clip != nil && !result.Deleted {
+				left = append(left, result)
+			}
+			switch {
+			case result.Clip == nil:
+				log.WithFields(log.Fields{
+					"segment": result.Segment,
+					"error":   result.Error,
+				}).Error("failed requesting clip")
+			case result.Filename == "":
+				log.WithFields(log.Fields{
+					"id":    result.Clip.ID,
+					"error": result.Error,
+				}).Error("clip did not become ready")
+			case result.Error != nil:
+				log.WithFields(log.Fields{
+					"id":       result.Clip.ID,
+					"filename": result.Filename,
+					"error":    result.Error,
+				}).Error("failed saving clip")
+				manifest.RecordFailure(result.Clip, result.Filename, result.Error)
+				saveManifest(manifest)
+			default:
+				recordManifest(manifest, result.Clip, result.Filename, gonest.ManifestFromExport)
+			}
+			if result.Clip != nil && result.Deleted {
+				// gonest removed the clip itself, sync must not take this for
+				// a clip deleted in the app
+				manifest.MarkDeletedBySync(result.Clip.ID)
+				saveManifest(manifest)
+			}
+			nest.Save()
+		},
+		Wait: gonest.WaitReadyOptions{
+			Progress: func(status gonest.WaitStatus) {
+				log.WithFields(log.Fields{
+					"id":       status.ID,
+					"attempts": status.Attempts,
+					"elapsed":  status.Elapsed,
+				}).Info("waiting for clip")
+			},
+		},
+		Options: saveOptions(c, progress),
+	}
+	_, err := nest.Export(context.Background(), id, pending, exporter)
+	progress.Close()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Fatal("failed exporting video")
+	}
+	return failed, left
+}
+
+// reportLeftClips lists the clips an export left on the server, failed ones
+// need someone to look at them before they are deleted
+func reportLeftClips(left []gonest.ExportResult) {
+	attention := 0
+	for _, result := range left {
+		fields := log.Fields{
+			"id":      result.Clip.ID,
+			"segment": result.Segment,
+			"link":    result.Clip.PublicLink,
+		}
+		if result.Kept {
+			log.WithFields(fields).Info("clip kept on the server")
+			continue
+		}
+		attention += 1
+		fields["error"] = result.Error
+		if result.Error == nil {
+			fields["error"] = "delete failed"
+		}
+		log.WithFields(fields).Warn("clip left on the server needs attention")
+	}
+	if attention > 0 {
+		log.WithFields(log.Fields{
+			"clips": attention,
+		}).Warn("clips were left on the server, rerun to retry them or remove them with delete-clip")
+	}
+}
+
+// concatVideo joins the segments of a finished video into one file per day
+// or one for the whole range, named by the name template. Segments are never
+// joined across a gap, the joined file would claim video it doesn't hold.
+func concatVideo(c *cli.Context, id string, checkpoint *gonest.Checkpoint, namer *clipNamer, manifest *gonest.Manifest) {
+	location := timezone(c)
+	directory := archiveDirectory(c, ".")
+	tolerance := c.Duration("gap-tolerance")
+	if c.String("concat") != "day" && c.String("concat") != "range" {
+		log.WithFields(log.Fields{
+			"concat": c.String("concat"),
+		}).Fatal("concat must be day or range")
+	}
+
+	var groups [][]gonest.CheckpointSegment
+	day := ""
+	for _, segment := range checkpoint.Segments() {
+		current := segment.Start.In(location).Format("2006-01-02")
+		switch {
+		case len(groups) == 0:
+			groups = append(groups, nil)
+		case c.String("concat") == "day" && current != day:
+			groups = append(groups, nil)
+		default:
+			group := groups[len(groups)-1]
+			previous := group[len(group)-1]
+			if segment.Start.Sub(previous.End) > tolerance {
+				log.WithFields(log.Fields{
+					"gap": gonest.TimeRange{Start: previous.End, End: segment.Start},
+				}).Info("not joining segments across a gap")
+				groups = append(groups, nil)
+			}
+		}
+		day = current
+		groups[len(groups)-1] = append(groups[len(groups)-1], segment)
+	}
+
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+
+		start := group[0].Start
+		end := group[len(group)-1].End
+		name := gonest.ClipName{
+			UUID:   id,
+			Camera: namer.cameras[id],
+			Start:  start,
+			End:    end,
+			Length: end.Sub(start).Seconds(),
+		}
+		output := filepath.Join(directory, namer.Filename(name))
+
+		var inputs []gonest.ConcatInput
+		for _, segment := range group {
+			if segment.Filename == output {
+				log.WithFields(log.Fields{
+					"output": output,
+				}).Fatal("joined file would overwrite a segment, use a name template with .Start and .End")
+			}
+			inputs = append(inputs, gonest.ConcatInput{
+				Filename: segment.Filename,
+				Title:    segment.Start.In(location).Format("2006-01-02 15:04"),
+			})
+		}
+
+		err := gonest.ConcatMP4(output, inputs)
+		if err == nil {
+			err = gonest.VerifyMP4(output, name.Length)
+		}
 		if err != nil {
-			panic(err)
+			log.WithFields(log.Fields{
+				"output": output,
+				"error":  err,
+			}).Error("failed joining segments")
+			continue
 		}
+		log.WithFields(log.Fields{
+			"output":   output,
+			"segments": len(group),
+			"start":    start,
+			"end":      end,
+		}).Info("joined segments")
+
+		// the joined file has to be in the manifest before any segment leaves
+		// it, or coverage, verify and prune lose track of the range
+		_, err = manifest.RecordJoined(id, gonest.TimeRange{Start: start, End: end}, output)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"output": output,
+				"error":  err,
+			}).Error("failed recording joined file in manifest, keeping segments")
+			continue
+		}
+		saveManifest(manifest)
+
+		if !c.Bool("concat-remove-segments") {
+			continue
+		}
+		for _, segment := range group {
+			var err error
+			if entry, ok := manifest.Get(segment.ClipID); ok && segment.ClipID != 0 {
+				err = manifest.DeleteLocal(entry)
+			} else {
+				err = os.Remove(segment.Filename)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"filename": segment.Filename,
+					"error":    err,
+				}).Error("failed removing segment")
+			}
+		}
+		saveManifest(manifest)
+	}
+}
 
-		clip.Save(fmt.Sprintf("videos/%s-%d-%d.mp4", id, i, i+3600))
-		clip.Delete()
+func saveCheckpoint(err error) {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("failed saving checkpoint")
 	}
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func loadManifest(directory string) *gonest.Manifest {
+	manifest, err := gonest.LoadManifest(directory)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": directory,
+			"error":     err,
+		}).Fatal("failed loading manifest")
+	}
+	return manifest
+}
+
+func saveManifest(manifest *gonest.Manifest) {
+	err := manifest.Save()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": manifest.Directory,
+			"error":     err,
+		}).Error("failed saving manifest")
+	}
+}
+
+func recordManifest(manifest *gonest.Manifest, clip *gonest.Clip, filename string, source gonest.ManifestSource) {
+	err := manifest.Record(clip, filename, source)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":       clip.ID,
+			"filename": filename,
+			"error":    err,
+		}).Error("failed recording clip in manifest")
+		return
+	}
+	saveManifest(manifest)
+}
+
+func ManifestImport(c *cli.Context) {
+	directory := requireArchiveDirectory(c)
+
+	manifest := loadManifest(directory)
+
+	var clips []*gonest.Clip
+	if !c.Bool("offline") {
+		nest.Load()
+		nest.Login()
+		nest.Save()
+
+		var err error
+		clips, err = nest.ListClips()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	result, err := manifest.Import(clips)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": directory,
+			"error":     err,
+		}).Fatal("failed importing directory")
+	}
+	saveManifest(manifest)
+
+	for _, entry := range result.Adopted {
+		log.WithFields(log.Fields{
+			"id":   entry.ID,
+			"path": entry.Path,
+		}).Info("adopted file")
+	}
+	for _, entry := range result.Moved {
+		log.WithFields(log.Fields{
+			"id":   entry.ID,
+			"path": entry.Path,
+		}).Info("found moved file")
+	}
+	for _, entry := range result.Missing {
+		log.WithFields(log.Fields{
+			"id":   entry.ID,
+			"path": entry.Path,
+		}).Warn("file is missing")
+	}
+	for _, filename := range result.Unmatched {
+		log.WithFields(log.Fields{
+			"filename": filename,
+		}).Warn("could not match file to a clip")
+	}
+
+	log.WithFields(log.Fields{
+		"adopted":   len(result.Adopted),
+		"moved":     len(result.Moved),
+		"missing":   len(result.Missing),
+		"unmatched": len(result.Unmatched),
+	}).Info("finished importing directory")
+}
+
+func ManifestKeep(c *cli.Context) {
+	directory := requireArchiveDirectory(c)
+	id := c.Int("id")
+	if id == 0 {
+		log.Fatal("id is required")
+	}
+
+	manifest := loadManifest(directory)
+	err := manifest.SetKeep(id, !c.Bool("unset"))
+	if errors.Is(err, gonest.ErrNotFound) {
+		log.WithFields(log.Fields{
+			"id": id,
+		}).Fatal("clip is not in the manifest")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Fatal("failed updating clip")
+	}
+	// the change is all this command does, it must not report success unsaved
+	err = manifest.Save()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"directory": manifest.Directory,
+			"error":     err,
+		}).Fatal("failed saving manifest")
+	}
+
+	log.WithFields(log.Fields{
+		"id":   id,
+		"keep": !c.Bool("unset"),
+	}).Info("updated clip")
+}
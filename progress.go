@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+)
+
+// progressDisplay renders per-clip and overall progress bars while stdout is
+// a terminal, otherwise it logs progress every logInterval
+type progressDisplay struct {
+	mu          sync.Mutex
+	tty         bool
+	out         io.Writer
+	logOut      io.Writer
+	active      map[int]gonest.Progress
+	lastLog     map[int]time.Time
+	logInterval time.Duration
+	total       int
+	finished    int
+	drawn       int
+	lastDraw    time.Time
+}
+
+func newProgressDisplay(total int) *progressDisplay {
+	d := &progressDisplay{
+		out:         os.Stdout,
+		logOut:      os.Stderr,
+		active:      make(map[int]gonest.Progress),
+		lastLog:     make(map[int]time.Time),
+		logInterval: 10 * time.Second,
+		total:       total,
+	}
+	info, err := os.Stdout.Stat()
+	if err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		d.tty = true
+		// log lines have to clear the bars before they are written
+		log.SetOutput(d)
+	}
+	return d
+}
+
+func (d *progressDisplay) Update(p gonest.Progress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous, known := d.active[p.ID]
+	if p.State == gonest.ProgressDone || p.State == gonest.ProgressFailed {
+		delete(d.active, p.ID)
+	} else {
+		d.active[p.ID] = p
+	}
+
+	if d.tty {
+		changed := !known || previous.State != p.State
+		if changed || time.Since(d.lastDraw) > 100*time.Millisecond {
+			d.redraw()
+		}
+		return
+	}
+
+	if p.State == gonest.ProgressDownloading && known && previous.State == p.State && time.Since(d.lastLog[p.ID]) < d.logInterval {
+		return
+	}
+	if p.State == gonest.ProgressWaiting && known && previous.State == p.State {
+		return
+	}
+	d.lastLog[p.ID] = time.Now()
+	log.WithFields(log.Fields{
+		"id":       p.ID,
+		"filename": p.Filename,
+		"state":    p.State,
+		"done":     formatBytes(p.Done),
+		"total":    formatBytes(p.Total),
+		"rate":     formatBytes(int64(p.Rate)) + "/s",
+		"eta":      p.ETA.Truncate(time.Second),
+	}).Info("download progress")
+}
+
+// Finished counts a clip towards the overall progress
+func (d *progressDisplay) Finished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.finished += 1
+	if d.tty {
+		d.redraw()
+	} else if d.total > 1 {
+		log.WithFields(log.Fields{
+			"finished": d.finished,
+			"total":    d.total,
+		}).Info("overall progress")
+	}
+}
+
+func (d *progressDisplay) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	n, err := d.logOut.Write(b)
+	d.draw()
+	return n, err
+}
+
+func (d *progressDisplay) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tty {
+		d.clear()
+		log.SetOutput(d.logOut)
+	}
+}
+
+func (d *progressDisplay) redraw() {
+	d.clear()
+	d.draw()
+}
+
+func (d *progressDisplay) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.out, "\033[%dA\033[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+func (d *progressDisplay) draw() {
+	if !d.tty {
+		return
+	}
+	d.lastDraw = time.Now()
+
+	var ids []int
+	for id := range d.active {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		p := d.active[id]
+		name := filepath.Base(p.Filename)
+		if len(name) > 30 {
+			name = name[:27] + "..."
+		}
+		switch p.State {
+		case gonest.ProgressWaiting:
+			fmt.Fprintf(d.out, "%-30s waiting for processing (attempt %d)\n", name, p.Attempts)
+		case gonest.ProgressVerifying:
+			fmt.Fprintf(d.out, "%-30s verifying\n", name)
+		default:
+			fmt.Fprintf(d.out, "%-30s %s %s/%s %s/s ETA %s\n", name, bar(p.Done, p.Total), formatBytes(p.Done), formatBytes(p.Total), formatBytes(int64(p.Rate)), p.ETA.Truncate(time.Second))
+		}
+		d.drawn += 1
+	}
+
+	if d.total > 1 {
+		fmt.Fprintf(d.out, "%-30s %s %d/%d clips\n", "overall", bar(int64(d.finished), int64(d.total)), d.finished, d.total)
+		d.drawn += 1
+	}
+}
+
+func bar(done int64, total int64) string {
+	const width = 30
+	if total <= 0 {
+		return "[" + strings.Repeat("?", width) + "]     "
+	}
+	filled := int(done * width / total)
+	if filled > width {
+		filled = width
+	}
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), done*100/total)
+}
+
+func formatBytes(n int64) string {
+	if n < 0 {
+		return "?"
+	}
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit += 1
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func Prune(c *cli.Context) {
+	directory := archiveDirectory(c, "")
+	var policy gonest.RetentionPolicy
+
+	if c.String("config") != "" {
+		config, err := gonest.LoadArchiveConfig(c.String("config"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"config": c.String("config"),
+				"error":  err,
+			}).Fatal("failed loading archive config")
+		}
+		if !c.IsSet("directory") && config.Directory != "" {
+			directory = archivePath(config.Directory)
+		}
+		policy = config.Retention
+	} else {
+		policy = gonest.RetentionPolicy{{
+			Camera:          c.String("camera"),
+			KeepDays:        c.Int("keep-days"),
+			MaxGB:           c.Float64("max-gb"),
+			HourlyAfterDays: c.Int("hourly-after-days"),
+		}}
+	}
+	if directory == "" {
+		log.Fatal("directory is required, use --directory, --config or --archive-root")
+	}
+	if policy.Empty() {
+		log.Fatal("no retention rules, use --keep-days, --max-gb or --hourly-after-days")
+	}
+
+	manifest := loadManifest(directory)
+	pruneArchive(manifest, policy, c.Bool("dry-run"), !c.Bool("yes"))
+}
+
+// pruneArchive applies policy to the manifest, printing the plan first. When
+// ask is set nothing is removed without confirmation.
+func pruneArchive(manifest *gonest.Manifest, policy gonest.RetentionPolicy, dryRun bool, ask bool) {
+	for _, rule := range policy {
+		camera := rule.Camera
+		if camera == "" {
+			camera = "default"
+		}
+		log.WithFields(log.Fields{
+			"camera": camera,
+			"rule":   rule,
+		}).Info("retention rule")
+	}
+
+	candidates := manifest.PlanPrune(policy, time.Now())
+	var size int64
+	for _, candidate := range candidates {
+		size += candidate.Entry.Size
+		fmt.Printf("prune  %-10s %s  %s  %s\n", candidate.Reason, candidate.Entry.Start.Format(time.RFC3339), formatBytes(candidate.Entry.Size), candidate.Entry.Path)
+	}
+	if len(candidates) == 0 {
+		log.Info("nothing to prune")
+		return
+	}
+	log.WithFields(log.Fields{
+		"files": len(candidates),
+		"size":  formatBytes(size),
+	}).Info("planned prune")
+
+	if dryRun {
+		return
+	}
+	if ask && !confirm(fmt.Sprintf("Remove %d files?", len(candidates))) {
+		log.Info("not pruning")
+		return
+	}
+
+	pruned := 0
+	for _, candidate := range candidates {
+		err := manifest.Prune(candidate.Entry)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  candidate.Entry.Path,
+				"error": err,
+			}).Error("failed pruning file")
+			continue
+		}
+		pruned += 1
+	}
+	saveManifest(manifest)
+
+	log.WithFields(log.Fields{
+		"pruned": pruned,
+		"failed": len(candidates) - pruned,
+	}).Info("finished pruning")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AdamJacobMuller/gonest/gonest"
+	log "github.com/sirupsen/logrus"
+	"github.com/tcnksm/go-input"
+	"github.com/urfave/cli"
+)
+
+func SyncClips(c *cli.Context) {
+	directory := requireArchiveDirectory(c)
+
+	nest.Load()
+	nest.Login()
+	nest.Save()
+
+	manifest := loadManifest(directory)
+	cleanupPartials(c, directory)
+
+	log.Info("listing clips")
+	clips, err := nest.ListClips()
+	if err != nil {
+		panic(err)
+	}
+	markRemoteDeleted(manifest, clips)
+
+	opts := gonest.SyncOptions{
+		DeleteLocal:       c.Bool("delete-local"),
+		DeleteRemoteAfter: time.Duration(c.Int("delete-remote-after-days")) * 24 * time.Hour,
+	}
+	plan := gonest.PlanSync(clips, manifest, opts)
+	printSyncPlan(manifest, plan)
+
+	if plan.Empty() {
+		log.Info("nothing to sync")
+		return
+	}
+	if c.Bool("dry-run") {
+		return
+	}
+	if (len(plan.DeleteLocal) > 0 || len(plan.DeleteRemote) > 0) && !c.Bool("yes") && !confirm("Apply this plan?") {
+		log.Info("not syncing")
+		return
+	}
+
+	failed := false
+
+	summary := downloadClips(c, directory, manifest, plan.Download)
+	if summary.Failed > 0 {
+		failed = true
+	}
+
+	for _, entry := range plan.DeleteLocal {
+		err := manifest.DeleteLocal(entry)
+		if err != nil {
+			failed = true
+			log.WithFields(log.Fields{
+				"id":    entry.ID,
+				"path":  entry.Path,
+				"error": err,
+			}).Error("failed deleting local copy")
+			continue
+		}
+		log.WithFields(log.Fields{
+			"id":   entry.ID,
+			"path": entry.Path,
+		}).Info("deleted local copy")
+	}
+	saveManifest(manifest)
+
+	for _, result := range nest.DeleteClipList(plan.DeleteRemote, c.Int("parallel")) {
+		if result.Error != nil {
+			failed = true
+			log.WithFields(log.Fields{
+				"id":    result.Clip.ID,
+				"error": result.Error,
+			}).Error("failed deleting clip from server")
+			continue
+		}
+		manifest.MarkDeletedBySync(result.Clip.ID)
+	}
+	nest.Save()
+
+	manifest.LastSync = time.Now().UTC()
+	saveManifest(manifest)
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printSyncPlan(manifest *gonest.Manifest, plan *gonest.SyncPlan) {
+	for _, clip := range plan.Download {
+		fmt.Printf("download\t%d\t%s\t%s\n", clip.ID, clip.StartTime().Format(time.RFC3339), clip.Title)
+	}
+	for _, entry := range plan.DeleteLocal {
+		fmt.Printf("delete local\t%d\t%s\n", entry.ID, manifest.FullPath(entry))
+	}
+	for _, clip := range plan.DeleteRemote {
+		fmt.Printf("delete remote\t%d\t%s\t%s\n", clip.ID, clip.StartTime().Format(time.RFC3339), clip.Title)
+	}
+	for _, clip := range plan.Unverified {
+		fmt.Printf("keep remote\t%d\tlocal copy failed verification\n", clip.ID)
+	}
+	fmt.Printf("%d to download, %d local and %d remote to delete\n", len(plan.Download), len(plan.DeleteLocal), len(plan.DeleteRemote))
+}
+
+func confirm(question string) bool {
+	ui := &input.UI{
+		Writer: os.Stdout,
+		Reader: os.Stdin,
+	}
+	answer, err := ui.Ask(fmt.Sprintf("%s [y/N]", question), &input.Options{
+		HideOrder: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}